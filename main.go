@@ -7,9 +7,18 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/burnettdev/adsb2loki/pkg/archive"
 	"github.com/burnettdev/adsb2loki/pkg/flightdata"
+	"github.com/burnettdev/adsb2loki/pkg/influx"
+	"github.com/burnettdev/adsb2loki/pkg/kafka"
 	"github.com/burnettdev/adsb2loki/pkg/logging"
 	"github.com/burnettdev/adsb2loki/pkg/loki"
+	"github.com/burnettdev/adsb2loki/pkg/metrics"
+	"github.com/burnettdev/adsb2loki/pkg/mqtt"
+	"github.com/burnettdev/adsb2loki/pkg/opensearch"
+	"github.com/burnettdev/adsb2loki/pkg/otlplog"
+	"github.com/burnettdev/adsb2loki/pkg/parquet"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
 	"github.com/burnettdev/adsb2loki/pkg/tracing"
 	"github.com/joho/godotenv"
 )
@@ -40,6 +49,21 @@ func main() {
 	}
 	defer shutdownTracing()
 
+	// Initialize OpenTelemetry metrics
+	shutdownOTelMetrics, err := tracing.InitMetrics()
+	if err != nil {
+		logger.Error("Failed to initialize OpenTelemetry metrics", "error", err)
+		shutdownOTelMetrics = func() {}
+	}
+	defer shutdownOTelMetrics()
+
+	shutdownMetrics, err := metrics.Serve(os.Getenv("METRICS_ADDR"))
+	if err != nil {
+		logger.Error("Failed to start Prometheus metrics endpoint", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownMetrics()
+
 	lokiURL := os.Getenv("LOKI_URL")
 	logger.Debug("Loki URL configuration", "url", lokiURL)
 
@@ -57,6 +81,40 @@ func main() {
 		lokiClient = loki.NewClient(lokiURL)
 	}
 
+	// Receivers are configured before any sink connects, so a bad receiver
+	// configuration exits without MQTT or Kafka connections left open
+	receiversConfig, err := flightdata.LoadConfig()
+	if err != nil {
+		logger.Error("Failed to load receivers configuration", "error", err)
+		os.Exit(1)
+	}
+	for _, r := range receiversConfig.Receivers {
+		logger.Info("Receiver configured", "receiver", r.Name, "url", r.URL, "uat_url", r.UATURL)
+	}
+
+	targets, err := outputSinks(lokiClient)
+	if err != nil {
+		logger.Error("Failed to configure output sinks", "error", err)
+		os.Exit(1)
+	}
+
+	out := sink.New(targets...)
+	defer func() {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelFlush()
+		if err := out.Flush(flushCtx); err != nil {
+			logger.Error("Failed to flush output sinks", "error", err)
+		}
+		if err := out.Close(); err != nil {
+			logger.Error("Failed to close output sinks", "error", err)
+		}
+	}()
+
+	for _, t := range targets {
+		receiversConfig.Snapshots = receiversConfig.Snapshots || t.Snapshots
+	}
+	poller := flightdata.NewPoller(receiversConfig)
+
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
@@ -71,7 +129,7 @@ func main() {
 		case <-ticker.C:
 			logger.Debug("Ticker fired - fetching data")
 
-			if err := flightdata.FetchAndPushToLoki(ctx, lokiClient); err != nil {
+			if err := poller.FetchAndPush(ctx, out); err != nil {
 				logger.Error("Error fetching and pushing data", "error", err)
 			} else {
 				logger.Debug("Data fetch and push completed successfully")
@@ -88,6 +146,96 @@ func main() {
 	}
 }
 
+// outputSinks returns Loki and every other output enabled by the environment.
+// Should one fail to configure, those already created are closed.
+func outputSinks(lokiClient *loki.Client) ([]sink.Target, error) {
+	logging.DebugCall("outputSinks")
+
+	targets := []sink.Target{{Name: "loki", Sink: lokiClient}}
+	configured := false
+	defer func() {
+		if configured {
+			return
+		}
+		for _, t := range targets {
+			if err := t.Sink.Close(); err != nil {
+				logging.Error("Failed to close output sink", "sink", t.Name, "error", err)
+			}
+		}
+	}()
+
+	if tracing.IsTrue(os.Getenv("OTLP_LOGS_ENABLED")) {
+		targets = append(targets, sink.Target{Name: "otlp_logs", Sink: otlplog.New()})
+	}
+
+	influxConfig, err := influx.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if influxConfig != nil {
+		targets = append(targets, sink.Target{Name: "influx", Sink: influx.New(*influxConfig)})
+	}
+
+	mqttConfig, err := mqtt.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if mqttConfig != nil {
+		mqttSink, err := mqtt.New(*mqttConfig)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, sink.Target{Name: "mqtt", Sink: mqttSink})
+	}
+
+	kafkaConfig, err := kafka.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if kafkaConfig != nil {
+		kafkaSink, err := kafka.New(*kafkaConfig)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, sink.Target{Name: "kafka", Sink: kafkaSink})
+	}
+
+	opensearchConfig, err := opensearch.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if opensearchConfig != nil {
+		targets = append(targets, sink.Target{Name: "opensearch", Sink: opensearch.New(*opensearchConfig)})
+	}
+
+	archiveConfig, err := archive.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if archiveConfig != nil {
+		archiveSink, err := archive.New(*archiveConfig)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, sink.Target{Name: "archive", Sink: archiveSink, Snapshots: archiveConfig.Snapshots})
+	}
+
+	parquetConfig, err := parquet.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if parquetConfig != nil {
+		parquetSink, err := parquet.New(*parquetConfig)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, sink.Target{Name: "parquet", Sink: parquetSink})
+	}
+
+	configured = true
+	return targets, nil
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	logging.DebugCall("getEnvOrDefault", "key", key, "default", defaultValue)
 
@@ -0,0 +1,348 @@
+package airports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/burnettdev/adsb2loki/pkg/geo"
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Movement event types.
+const (
+	EventTakeoff = "takeoff"
+	EventLanding = "landing"
+)
+
+const (
+	// maxDistance is how far, in nautical miles, from an airport's reference
+	// point a movement is attributed to it
+	maxDistance = 5.0
+	// maxRunwayDeviation is how far, in degrees, the aircraft's track may be
+	// from a runway heading for the movement to be on that runway
+	maxRunwayDeviation = 30.0
+	// gridSize is the size in degrees of the cells airports are indexed by
+	gridSize = 1.0
+)
+
+// Airport is an airport from the OurAirports airports.csv.
+type Airport struct {
+	Ident     string
+	Type      string
+	Name      string
+	IATA      string
+	Country   string
+	Lat       float64
+	Lon       float64
+	Elevation *int
+	Runways   []Runway
+}
+
+// Runway is a runway from the OurAirports runways.csv, with its two ends.
+type Runway struct {
+	LengthFt int
+	Surface  string
+	Ends     []RunwayEnd
+}
+
+// RunwayEnd is one direction of a runway, such as "27L", with its threshold
+// position when known.
+type RunwayEnd struct {
+	Ident   string
+	Heading float64
+	Lat     *float64
+	Lon     *float64
+}
+
+// Database indexes airports by position.
+type Database struct {
+	airports []Airport
+	grid     map[[2]int][]int
+}
+
+// Load reads an OurAirports airports.csv and, optionally, its runways.csv.
+// Closed airports and runways are skipped.
+func Load(airportsPath, runwaysPath string) (*Database, error) {
+	logging.DebugCall("airports.Load", "airports", airportsPath, "runways", runwaysPath)
+
+	db := &Database{grid: make(map[[2]int][]int)}
+	index := make(map[string]int)
+
+	err := readCSV(airportsPath, func(row func(string) string) error {
+		if row("type") == "closed" {
+			return nil
+		}
+
+		lat, err := strconv.ParseFloat(row("latitude_deg"), 64)
+		if err != nil {
+			return fmt.Errorf("airport %s: invalid latitude_deg: %w", row("ident"), err)
+		}
+		lon, err := strconv.ParseFloat(row("longitude_deg"), 64)
+		if err != nil {
+			return fmt.Errorf("airport %s: invalid longitude_deg: %w", row("ident"), err)
+		}
+
+		airport := Airport{
+			Ident:   row("ident"),
+			Type:    row("type"),
+			Name:    row("name"),
+			IATA:    row("iata_code"),
+			Country: row("iso_country"),
+			Lat:     lat,
+			Lon:     lon,
+		}
+		if elevation, err := strconv.Atoi(row("elevation_ft")); err == nil {
+			airport.Elevation = models.Ptr(elevation)
+		}
+
+		index[airport.Ident] = len(db.airports)
+		db.airports = append(db.airports, airport)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load airports: %w", err)
+	}
+
+	if runwaysPath != "" {
+		err := readCSV(runwaysPath, func(row func(string) string) error {
+			i, ok := index[row("airport_ident")]
+			if !ok || row("closed") == "1" {
+				return nil
+			}
+
+			runway := Runway{Surface: row("surface")}
+			runway.LengthFt, _ = strconv.Atoi(row("length_ft"))
+
+			for _, end := range []string{"le", "he"} {
+				ident := row(end + "_ident")
+				if ident == "" {
+					continue
+				}
+				heading, ok := runwayHeading(row, end, ident)
+				if !ok {
+					continue
+				}
+				runwayEnd := RunwayEnd{Ident: ident, Heading: heading}
+				lat, errLat := strconv.ParseFloat(row(end+"_latitude_deg"), 64)
+				lon, errLon := strconv.ParseFloat(row(end+"_longitude_deg"), 64)
+				if errLat == nil && errLon == nil {
+					runwayEnd.Lat, runwayEnd.Lon = models.Ptr(lat), models.Ptr(lon)
+				}
+				runway.Ends = append(runway.Ends, runwayEnd)
+			}
+
+			if len(runway.Ends) > 0 {
+				db.airports[i].Runways = append(db.airports[i].Runways, runway)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load runways: %w", err)
+		}
+	}
+
+	for i, a := range db.airports {
+		c := cellOf(a.Lat, a.Lon)
+		db.grid[c] = append(db.grid[c], i)
+	}
+
+	logging.Info("Airport database loaded", "airports", len(db.airports), "path", airportsPath)
+	return db, nil
+}
+
+// runwayHeading returns the true heading of a runway end, falling back to
+// the bearing between its thresholds and then to its designator.
+func runwayHeading(row func(string) string, end, ident string) (float64, bool) {
+	if heading, err := strconv.ParseFloat(row(end+"_heading_degT"), 64); err == nil {
+		return heading, true
+	}
+
+	other := "he"
+	if end == "he" {
+		other = "le"
+	}
+	lat1, err1 := strconv.ParseFloat(row(end+"_latitude_deg"), 64)
+	lon1, err2 := strconv.ParseFloat(row(end+"_longitude_deg"), 64)
+	lat2, err3 := strconv.ParseFloat(row(other+"_latitude_deg"), 64)
+	lon2, err4 := strconv.ParseFloat(row(other+"_longitude_deg"), 64)
+	if err1 == nil && err2 == nil && err3 == nil && err4 == nil {
+		return geo.Bearing(lat1, lon1, lat2, lon2), true
+	}
+
+	// "09L" is roughly 090 degrees magnetic
+	digits := strings.TrimRight(ident, "LCRW")
+	if n, err := strconv.Atoi(digits); err == nil && n >= 1 && n <= 36 {
+		return float64(n * 10), true
+	}
+	return 0, false
+}
+
+// Movement builds a takeoff or landing event for an aircraft at the nearest
+// airport, on the runway best matching its track. It returns false when no
+// airport is close enough.
+func (db *Database) Movement(eventType string, aircraft models.Aircraft, now float64) (models.Event, bool) {
+	lat, lon, _, ok := aircraft.LastKnownPosition()
+	if !ok {
+		return models.Event{}, false
+	}
+
+	type candidate struct {
+		airport  *Airport
+		distance float64
+		runway   *RunwayEnd
+	}
+
+	var candidates []candidate
+	c := cellOf(lat, lon)
+	for dLat := -1; dLat <= 1; dLat++ {
+		for dLon := -1; dLon <= 1; dLon++ {
+			for _, i := range db.grid[[2]int{c[0] + dLat, c[1] + dLon}] {
+				a := &db.airports[i]
+				distance := geo.Distance(lat, lon, a.Lat, a.Lon)
+				if distance > maxDistance {
+					continue
+				}
+				cand := candidate{airport: a, distance: distance}
+				if aircraft.Track != nil {
+					cand.runway = a.runway(*aircraft.Track, lat, lon)
+				}
+				candidates = append(candidates, cand)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return models.Event{}, false
+	}
+
+	// An airport with a runway matching the track beats a closer one
+	// without, such as a heliport next to the runway
+	sort.Slice(candidates, func(i, j int) bool {
+		if (candidates[i].runway != nil) != (candidates[j].runway != nil) {
+			return candidates[i].runway != nil
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+	best := candidates[0]
+
+	event := models.NewEvent(eventType, now, aircraft)
+	event.Details = map[string]interface{}{
+		"airport":      best.airport.Ident,
+		"airport_name": best.airport.Name,
+		"distance_nm":  math.Round(best.distance*100) / 100,
+		"track":        aircraft.Track,
+	}
+	if best.airport.IATA != "" {
+		event.Details["iata"] = best.airport.IATA
+	}
+	if best.runway != nil {
+		event.Details["runway"] = best.runway.Ident
+	}
+	return event, true
+}
+
+// runway returns the runway end whose heading matches track. Between
+// parallel runways the one whose centreline is closest to the aircraft wins.
+func (a *Airport) runway(track, lat, lon float64) *RunwayEnd {
+	var (
+		best      *RunwayEnd
+		bestScore = math.Inf(1)
+	)
+	for i := range a.Runways {
+		offset, hasOffset := a.Runways[i].offset(lat, lon)
+		for j := range a.Runways[i].Ends {
+			end := &a.Runways[i].Ends[j]
+			deviation := geo.HeadingDifference(track, end.Heading)
+			if deviation > maxRunwayDeviation {
+				continue
+			}
+			// Rank by distance from the centreline in nautical miles when
+			// the thresholds are known, otherwise by heading alone
+			score := deviation
+			if hasOffset {
+				score = offset*1000 + deviation
+			}
+			if score < bestScore {
+				best, bestScore = end, score
+			}
+		}
+	}
+	return best
+}
+
+// offset returns the distance in nautical miles from a point to the runway's
+// centreline between its two thresholds.
+func (r Runway) offset(lat, lon float64) (float64, bool) {
+	if len(r.Ends) != 2 || r.Ends[0].Lat == nil || r.Ends[1].Lat == nil {
+		return 0, false
+	}
+
+	// Project onto a local flat plane around the point, in nautical miles
+	scale := math.Cos(lat * math.Pi / 180)
+	project := func(pLat, pLon float64) (float64, float64) {
+		return (pLon - lon) * 60 * scale, (pLat - lat) * 60
+	}
+	x1, y1 := project(*r.Ends[0].Lat, *r.Ends[0].Lon)
+	x2, y2 := project(*r.Ends[1].Lat, *r.Ends[1].Lon)
+
+	dx, dy := x2-x1, y2-y1
+	length := dx*dx + dy*dy
+	if length == 0 {
+		return math.Hypot(x1, y1), true
+	}
+	t := math.Max(0, math.Min(1, -(x1*dx+y1*dy)/length))
+	return math.Hypot(x1+t*dx, y1+t*dy), true
+}
+
+func cellOf(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / gridSize)), int(math.Floor(lon / gridSize))}
+}
+
+// readCSV calls fn for every record of a CSV file with a header row, passing
+// a function that looks up a column by name.
+func readCSV(path string, fn func(row func(string) string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		row := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
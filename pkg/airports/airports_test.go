@@ -0,0 +1,153 @@
+package airports
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+func load(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := Load(filepath.Join("testdata", "airports.csv"), filepath.Join("testdata", "runways.csv"))
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	return db
+}
+
+func find(db *Database, ident string) *Airport {
+	for i := range db.airports {
+		if db.airports[i].Ident == ident {
+			return &db.airports[i]
+		}
+	}
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	db := load(t)
+
+	if len(db.airports) != 4 || find(db, "GB-0998") != nil {
+		t.Errorf("loaded %d airports, want 4 without the closed one", len(db.airports))
+	}
+
+	lhr := find(db, "EGLL")
+	if lhr == nil {
+		t.Fatal("EGLL not loaded")
+	}
+	if lhr.IATA != "LHR" || lhr.Name != "London Heathrow Airport" || lhr.Country != "GB" || models.Deref(lhr.Elevation) != 83 {
+		t.Errorf("EGLL = %+v", *lhr)
+	}
+	if len(lhr.Runways) != 2 {
+		t.Fatalf("EGLL has %d runways, want 2 without the closed one", len(lhr.Runways))
+	}
+	if end := lhr.Runways[0].Ends[1]; end.Ident != "27R" || end.Heading != 269.6 || *end.Lat != 51.4777 {
+		t.Errorf("EGLL 27R = %+v", end)
+	}
+	if heli := find(db, "GB-0999"); heli.Elevation != nil {
+		t.Errorf("heliport elevation = %v, want unset", *heli.Elevation)
+	}
+
+	// Without headings, they come from the thresholds, then the designator
+	headings := map[string][]float64{
+		"EGLC": {88, 268},
+		"EGTF": {60, 240},
+	}
+	for ident, want := range headings {
+		ends := find(db, ident).Runways[0].Ends
+		for i, end := range ends {
+			if math.Abs(end.Heading-want[i]) > 1 {
+				t.Errorf("%s %s heading = %v, want about %v", ident, end.Ident, end.Heading, want[i])
+			}
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(filepath.Join("testdata", "missing.csv"), ""); err == nil {
+		t.Error("Load() accepted a missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "airports.csv")
+	csv := "ident,type,latitude_deg,longitude_deg\nEGLL,large_airport,north,-0.46\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, ""); err == nil {
+		t.Error("Load() accepted an invalid latitude")
+	}
+
+	db, err := Load(filepath.Join("testdata", "airports.csv"), "")
+	if err != nil || len(find(db, "EGLL").Runways) != 0 {
+		t.Errorf("Load() without runways = %v", err)
+	}
+}
+
+func TestMovement(t *testing.T) {
+	db := load(t)
+
+	tests := map[string]struct {
+		lat, lon float64
+		track    *float64
+		airport  string
+		runway   string
+	}{
+		"southern runway westbound":  {51.4649, -0.45, models.Ptr(270.0), "EGLL", "27L"},
+		"northern runway westbound":  {51.4776, -0.45, models.Ptr(271.0), "EGLL", "27R"},
+		"northern runway eastbound":  {51.4776, -0.45, models.Ptr(89.0), "EGLL", "09L"},
+		"30 degrees off the runway":  {51.4776, -0.45, models.Ptr(119.0), "EGLL", "09L"},
+		"runway beats a heliport":    {51.4702, -0.45, models.Ptr(270.0), "EGLL", "27L"},
+		"no runway matches":          {51.4702, -0.45, models.Ptr(135.0), "GB-0999", ""},
+		"no track":                   {51.4702, -0.45, nil, "GB-0999", ""},
+		"airport in the next cell":   {51.505, -0.01, models.Ptr(88.0), "EGLC", "09"},
+		"runway from its designator": {51.348, -0.56, models.Ptr(240.0), "EGTF", "24"},
+		"too far from any airport":   {51.0, -1.5, models.Ptr(270.0), "", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := models.Aircraft{Hex: "4ca1fa", Lat: models.Ptr(tt.lat), Lon: models.Ptr(tt.lon), Track: tt.track}
+			e, ok := db.Movement(EventLanding, a, 1000)
+			if tt.airport == "" {
+				if ok {
+					t.Errorf("Movement() found %v", e.Details["airport"])
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("Movement() found no airport")
+			}
+			if e.Type != EventLanding || e.Hex != "4ca1fa" || e.Time != 1000 {
+				t.Errorf("event = %+v", e)
+			}
+			if e.Details["airport"] != tt.airport {
+				t.Errorf("airport = %v, want %s", e.Details["airport"], tt.airport)
+			}
+			if runway, _ := e.Details["runway"].(string); runway != tt.runway {
+				t.Errorf("runway = %q, want %q", runway, tt.runway)
+			}
+		})
+	}
+
+	if _, ok := db.Movement(EventTakeoff, models.Aircraft{Hex: "4ca1fa"}, 1000); ok {
+		t.Error("Movement() found an airport for an aircraft without a position")
+	}
+}
+
+func TestMovementIATA(t *testing.T) {
+	db := load(t)
+
+	a := models.Aircraft{Lat: models.Ptr(51.47), Lon: models.Ptr(-0.47), Track: models.Ptr(90.0)}
+	e, _ := db.Movement(EventTakeoff, a, 1000)
+	if e.Details["iata"] != "LHR" || e.Details["airport_name"] != "London Heathrow Airport" {
+		t.Errorf("details = %v", e.Details)
+	}
+
+	a = models.Aircraft{Lat: models.Ptr(51.348), Lon: models.Ptr(-0.56)}
+	if e, _ := db.Movement(EventTakeoff, a, 1000); e.Details["iata"] != nil {
+		t.Errorf("iata = %v for an airport without one", e.Details["iata"])
+	}
+}
@@ -0,0 +1,453 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+
+	"github.com/burnettdev/adsb2loki/pkg/geo"
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Event types emitted by the detector.
+const (
+	EventTeleport         = "teleport"
+	EventTrackMismatch    = "track_mismatch"
+	EventClimbRate        = "climb_rate"
+	EventMLATDisagreement = "mlat_disagreement"
+	EventGPSJamming       = "gps_jamming"
+	EventGPSJammingClear  = "gps_jamming_cleared"
+)
+
+const (
+	// minJump is the smallest position change, in nautical miles, checked
+	// against the reported speed and track, to stay clear of position noise
+	minJump = 2.0
+	// speedMargin and speedSlack allow for gusts, rounding and a missed
+	// speed update before an implied speed counts as impossible
+	speedMargin = 1.5
+	speedSlack  = 100.0
+	// maxUnknownSpeed applies when no ground speed was reported
+	maxUnknownSpeed = 1000.0
+	// maxTrackGap is the longest interval, in seconds, over which a
+	// position change is expected to follow the reported track
+	maxTrackGap    = 30.0
+	maxTrackChange = 90.0
+
+	// minClimbInterval and maxClimbInterval bound the seconds between
+	// altitude samples used to measure a climb rate
+	minClimbInterval = 5.0
+	maxClimbInterval = 60.0
+	// maxRateDifference is how far, in ft/min, the measured climb rate may
+	// stray from the reported one; maxClimbRate applies when none is reported
+	maxRateDifference = 6000.0
+	maxClimbRate      = 15000.0
+
+	// maxMLATAge is the longest interval, in seconds, between an ADS-B and
+	// an MLAT position for them to be compared
+	maxMLATAge      = 10.0
+	maxMLATDistance = 3.0
+
+	// Integrity below these is treated as a degraded GPS solution
+	minGoodNIC  = 7
+	minGoodNACp = 8
+	// integrityMemory is how long, in seconds, an aircraft that had good
+	// integrity counts towards a collapse
+	integrityMemory = 600.0
+	// A grid cell is jammed when at least minJammed aircraft in it lost
+	// integrity and they make up jammedFraction of those reporting it
+	gridSize       = 1.0
+	minJammed      = 3
+	jammedFraction = 0.3
+
+	// cooldown is the minimum number of seconds between two events of the
+	// same type about the same aircraft
+	cooldown = 300.0
+	// trackTimeout is how long, in seconds, an unheard aircraft is remembered
+	trackTimeout = 600.0
+)
+
+// Observation is one report of an aircraft: the aircraft, the receiver that
+// heard it and the snapshot time it belongs to.
+type Observation struct {
+	Aircraft models.Aircraft
+	Receiver string
+	Now      float64
+}
+
+// Detector compares each aircraft against its own history, and aircraft in
+// the same region against each other, to find reports that can't be real.
+// It keeps state between polling cycles and is not safe for concurrent use.
+type Detector struct {
+	tracks map[string]*track
+	jammed map[cell]bool
+}
+
+type track struct {
+	lastSeen float64
+	fixes    map[models.Source]fix
+	altitude *altitudeSample
+	// integrityOK is when the aircraft last reported good integrity
+	integrityOK float64
+	// reported is when each event type was last emitted for the aircraft
+	reported map[string]float64
+}
+
+type fix struct {
+	lat, lon, t float64
+	gs, track   *float64
+}
+
+type altitudeSample struct {
+	alt int
+	t   float64
+}
+
+type cell struct {
+	lat, lon int
+}
+
+func NewDetector() *Detector {
+	logging.DebugCall("anomaly.NewDetector")
+
+	return &Detector{
+		tracks: make(map[string]*track),
+		jammed: make(map[cell]bool),
+	}
+}
+
+// Detect checks one polling cycle's observations, from every receiver, and
+// returns the anomalies found.
+func (d *Detector) Detect(observations []Observation) []models.Event {
+	logging.DebugCall("anomaly.Detect", "observations", len(observations))
+
+	var (
+		events []models.Event
+		latest float64
+	)
+
+	// Oldest first so each position is compared with the one before it
+	sort.SliceStable(observations, func(i, j int) bool {
+		return observations[i].Now < observations[j].Now
+	})
+
+	for _, o := range observations {
+		t := d.tracks[o.Aircraft.Hex]
+		if t == nil {
+			t = &track{
+				fixes:    make(map[models.Source]fix),
+				reported: make(map[string]float64),
+			}
+			d.tracks[o.Aircraft.Hex] = t
+		}
+
+		for _, e := range t.observe(o) {
+			if last, ok := t.reported[e.Type]; ok && o.Now-last < cooldown {
+				continue
+			}
+			t.reported[e.Type] = o.Now
+			e.Receiver = o.Receiver
+			events = append(events, e)
+		}
+
+		latest = math.Max(latest, o.Now)
+	}
+
+	events = append(events, d.jamming(observations, latest)...)
+
+	for hex, t := range d.tracks {
+		if latest-t.lastSeen > trackTimeout {
+			delete(d.tracks, hex)
+		}
+	}
+
+	if len(events) > 0 {
+		logging.Debug("Anomalies detected", "events", len(events), "tracks", len(d.tracks))
+	}
+	return events
+}
+
+// observe updates the track with a new report and returns the kinematic
+// anomalies it reveals.
+func (t *track) observe(o Observation) []models.Event {
+	a := o.Aircraft
+	t.lastSeen = math.Max(t.lastSeen, o.Now)
+
+	var events []models.Event
+
+	if a.HasPosition() {
+		source := a.Source()
+		current := fix{
+			lat:   *a.Lat,
+			lon:   *a.Lon,
+			t:     o.Now - models.Deref(a.SeenPos),
+			gs:    a.Gs,
+			track: a.Track,
+		}
+
+		if previous, ok := t.fixes[source]; ok && current.t > previous.t {
+			events = append(events, checkMovement(a, previous, current)...)
+		}
+
+		var other models.Source
+		switch source {
+		case models.SourceADSB:
+			other = models.SourceMLAT
+		case models.SourceMLAT:
+			other = models.SourceADSB
+		}
+		if previous, ok := t.fixes[other]; ok && other != "" {
+			if e, found := checkMLAT(a, source, current, other, previous); found {
+				events = append(events, e)
+			}
+		}
+
+		if previous, ok := t.fixes[source]; !ok || current.t > previous.t {
+			t.fixes[source] = current
+		}
+	}
+
+	if a.AltBaro != nil {
+		current := altitudeSample{alt: *a.AltBaro, t: o.Now - a.Seen}
+		if t.altitude != nil {
+			if e, found := checkClimb(a, *t.altitude, current); found {
+				events = append(events, e)
+			}
+		}
+		// Keep the older sample until the next one is far enough apart to
+		// measure a rate, so closely spaced reports from several receivers
+		// don't keep replacing it
+		if t.altitude == nil || current.t-t.altitude.t >= minClimbInterval {
+			t.altitude = &current
+		}
+	}
+
+	if integrityGood(a) {
+		t.integrityOK = o.Now
+	}
+
+	for i := range events {
+		events[i].Time = o.Now
+	}
+	return events
+}
+
+// checkMovement compares the distance and direction between two successive
+// positions from the same source with the reported ground speed and track.
+func checkMovement(a models.Aircraft, previous, current fix) []models.Event {
+	distance := geo.Distance(previous.lat, previous.lon, current.lat, current.lon)
+	if distance < minJump {
+		return nil
+	}
+
+	elapsed := current.t - previous.t
+	implied := distance / elapsed * 3600
+
+	var events []models.Event
+
+	limit := maxUnknownSpeed
+	var reported *float64
+	if previous.gs != nil || current.gs != nil {
+		reported = models.Ptr(math.Max(models.Deref(previous.gs), models.Deref(current.gs)))
+		limit = *reported*speedMargin + speedSlack
+	}
+	if implied > limit {
+		e := models.NewEvent(EventTeleport, 0, a)
+		e.Details = map[string]interface{}{
+			"source":         a.Source(),
+			"from_lat":       previous.lat,
+			"from_lon":       previous.lon,
+			"distance_nm":    round(distance),
+			"elapsed_s":      round(elapsed),
+			"implied_gs_kt":  round(implied),
+			"reported_gs_kt": reported,
+			"allowed_gs_kt":  round(limit),
+		}
+		events = append(events, e)
+	}
+
+	if current.track != nil && elapsed <= maxTrackGap {
+		bearing := geo.Bearing(previous.lat, previous.lon, current.lat, current.lon)
+		if diff := geo.HeadingDifference(bearing, *current.track); diff > maxTrackChange {
+			e := models.NewEvent(EventTrackMismatch, 0, a)
+			e.Details = map[string]interface{}{
+				"source":         a.Source(),
+				"from_lat":       previous.lat,
+				"from_lon":       previous.lon,
+				"distance_nm":    round(distance),
+				"elapsed_s":      round(elapsed),
+				"bearing":        round(bearing),
+				"reported_track": *current.track,
+				"difference":     round(diff),
+			}
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// checkClimb compares the climb rate measured between two altitude samples
+// with the reported barometric (or geometric) vertical rate.
+func checkClimb(a models.Aircraft, previous, current altitudeSample) (models.Event, bool) {
+	elapsed := current.t - previous.t
+	if elapsed < minClimbInterval || elapsed > maxClimbInterval {
+		return models.Event{}, false
+	}
+
+	measured := float64(current.alt-previous.alt) / elapsed * 60
+
+	rate := a.BaroRate
+	if rate == nil {
+		rate = a.GeomRate
+	}
+
+	switch {
+	case rate != nil && math.Abs(measured-float64(*rate)) <= maxRateDifference:
+		return models.Event{}, false
+	case rate == nil && math.Abs(measured) <= maxClimbRate:
+		return models.Event{}, false
+	}
+
+	e := models.NewEvent(EventClimbRate, 0, a)
+	e.Details = map[string]interface{}{
+		"from_alt_baro":     previous.alt,
+		"alt_baro":          current.alt,
+		"elapsed_s":         round(elapsed),
+		"measured_rate_fpm": math.Round(measured),
+		"reported_rate_fpm": rate,
+	}
+	return e, true
+}
+
+// checkMLAT compares an ADS-B position with a recent MLAT position of the
+// same aircraft, allowing for the distance it could have flown in between.
+func checkMLAT(a models.Aircraft, source models.Source, current fix, otherSource models.Source, other fix) (models.Event, bool) {
+	elapsed := math.Abs(current.t - other.t)
+	if elapsed > maxMLATAge {
+		return models.Event{}, false
+	}
+
+	distance := geo.Distance(other.lat, other.lon, current.lat, current.lon)
+	allowed := maxMLATDistance + math.Max(models.Deref(current.gs), models.Deref(other.gs))*elapsed/3600
+	if distance <= allowed {
+		return models.Event{}, false
+	}
+
+	adsb, mlat := current, other
+	if source == models.SourceMLAT {
+		adsb, mlat = other, current
+	}
+
+	e := models.NewEvent(EventMLATDisagreement, 0, a)
+	e.Details = map[string]interface{}{
+		"adsb_lat":     adsb.lat,
+		"adsb_lon":     adsb.lon,
+		"mlat_lat":     mlat.lat,
+		"mlat_lon":     mlat.lon,
+		"distance_nm":  round(distance),
+		"allowed_nm":   round(allowed),
+		"elapsed_s":    round(elapsed),
+		"newer_source": source,
+		"older_source": otherSource,
+	}
+	return e, true
+}
+
+// jamming groups ADS-B aircraft reporting integrity into grid cells and flags
+// cells where many aircraft that recently had a good GPS solution lost it at
+// once. Each cell is reported when it becomes jammed and when it clears.
+func (d *Detector) jamming(observations []Observation, now float64) []models.Event {
+	type counts struct {
+		reporting, collapsed int
+		hexes                []string
+	}
+
+	cells := make(map[cell]*counts)
+	seen := make(map[string]bool)
+
+	for _, o := range observations {
+		a := o.Aircraft
+		if seen[a.Hex] || a.Source() != models.SourceADSB || (a.Nic == nil && a.NacP == nil) {
+			continue
+		}
+		lat, lon, _, ok := a.LastKnownPosition()
+		if !ok {
+			continue
+		}
+		seen[a.Hex] = true
+
+		c := cell{lat: int(math.Floor(lat / gridSize)), lon: int(math.Floor(lon / gridSize))}
+		if cells[c] == nil {
+			cells[c] = &counts{}
+		}
+		cells[c].reporting++
+
+		t := d.tracks[a.Hex]
+		if !integrityGood(a) && t != nil && t.integrityOK > 0 && o.Now-t.integrityOK <= integrityMemory {
+			cells[c].collapsed++
+			cells[c].hexes = append(cells[c].hexes, a.Hex)
+		}
+	}
+
+	var events []models.Event
+	event := func(eventType string, c cell, n counts) models.Event {
+		sort.Strings(n.hexes)
+		if n.hexes == nil {
+			n.hexes = []string{}
+		}
+		return models.Event{
+			Type: eventType,
+			Time: now,
+			Lat:  models.Ptr((float64(c.lat) + 0.5) * gridSize),
+			Lon:  models.Ptr((float64(c.lon) + 0.5) * gridSize),
+			Details: map[string]interface{}{
+				"grid_size":          gridSize,
+				"aircraft_reporting": n.reporting,
+				"aircraft_degraded":  n.collapsed,
+				"hexes":              n.hexes,
+			},
+		}
+	}
+
+	for c, n := range cells {
+		jammed := n.collapsed >= minJammed && float64(n.collapsed) >= jammedFraction*float64(n.reporting)
+		if jammed == d.jammed[c] {
+			continue
+		}
+
+		eventType := EventGPSJammingClear
+		if jammed {
+			eventType = EventGPSJamming
+			d.jammed[c] = true
+		} else {
+			delete(d.jammed, c)
+		}
+		events = append(events, event(eventType, c, *n))
+	}
+
+	// A jammed cell with no aircraft left in it can't be judged; clear it,
+	// so every jamming event is still followed by a clear
+	for c := range d.jammed {
+		if cells[c] == nil {
+			delete(d.jammed, c)
+			events = append(events, event(EventGPSJammingClear, c, counts{}))
+		}
+	}
+
+	return events
+}
+
+func integrityGood(a models.Aircraft) bool {
+	if a.Nic != nil && *a.Nic < minGoodNIC {
+		return false
+	}
+	if a.NacP != nil && *a.NacP < minGoodNACp {
+		return false
+	}
+	return a.Nic != nil || a.NacP != nil
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,265 @@
+package anomaly
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+func at(hex string, now, lat, lon float64) Observation {
+	return Observation{
+		Aircraft: models.Aircraft{Hex: hex, Lat: models.Ptr(lat), Lon: models.Ptr(lon)},
+		Receiver: "home",
+		Now:      now,
+	}
+}
+
+func withSpeed(o Observation, gs float64, track *float64) Observation {
+	o.Aircraft.Gs = models.Ptr(gs)
+	o.Aircraft.Track = track
+	return o
+}
+
+func climbing(hex string, now float64, alt, rate int) Observation {
+	return Observation{
+		Aircraft: models.Aircraft{Hex: hex, AltBaro: models.Ptr(alt), BaroRate: models.Ptr(rate)},
+		Now:      now,
+	}
+}
+
+func types(events []models.Event) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// detect feeds each cycle to a new detector and returns the event types of
+// every cycle.
+func detect(cycles ...[]Observation) [][]string {
+	d := NewDetector()
+	var got [][]string
+	for _, observations := range cycles {
+		got = append(got, types(d.Detect(observations)))
+	}
+	return got
+}
+
+func TestKinematics(t *testing.T) {
+	north, south := models.Ptr(0.0), models.Ptr(180.0)
+	mlat := at("4ca1fa", 1005, 51.1, 0)
+	mlat.Aircraft.Type = models.TypeMLAT
+
+	tests := map[string]struct {
+		cycles [][]Observation
+		want   [][]string
+	}{
+		// One degree of latitude, 60 nm, in ten seconds
+		"teleport": {
+			[][]Observation{
+				{withSpeed(at("4ca1fa", 1000, 51, 0), 400, north)},
+				{withSpeed(at("4ca1fa", 1010, 52, 0), 400, north)},
+			},
+			[][]string{nil, {EventTeleport}},
+		},
+		"steady flight": {
+			[][]Observation{
+				{withSpeed(at("4ca1fa", 1000, 51, 0), 450, north)},
+				{withSpeed(at("4ca1fa", 1030, 51.0625, 0), 450, north)},
+			},
+			[][]string{nil, nil},
+		},
+		"jump too small to judge": {
+			[][]Observation{
+				{withSpeed(at("4ca1fa", 1000, 51, 0), 0, north)},
+				{withSpeed(at("4ca1fa", 1001, 51.03, 0), 0, south)},
+			},
+			[][]string{nil, nil},
+		},
+		// Three nm north in 20 s at 450 kt is plausible, but not on a
+		// southerly track
+		"track mismatch": {
+			[][]Observation{
+				{withSpeed(at("4ca1fa", 1000, 51, 0), 450, south)},
+				{withSpeed(at("4ca1fa", 1020, 51.05, 0), 450, south)},
+			},
+			[][]string{nil, {EventTrackMismatch}},
+		},
+		"track not compared over a long gap": {
+			[][]Observation{
+				{withSpeed(at("4ca1fa", 1000, 51, 0), 450, south)},
+				{withSpeed(at("4ca1fa", 1060, 51.1, 0), 450, south)},
+			},
+			[][]string{nil, nil},
+		},
+		// 5000 ft in 10 s is 30,000 ft/min against a reported 0
+		"climb rate": {
+			[][]Observation{
+				{climbing("4ca1fa", 1000, 10000, 0)},
+				{climbing("4ca1fa", 1010, 15000, 0)},
+			},
+			[][]string{nil, {EventClimbRate}},
+		},
+		"climb matching the reported rate": {
+			[][]Observation{
+				{climbing("4ca1fa", 1000, 10000, 1800)},
+				{climbing("4ca1fa", 1010, 10300, 1800)},
+			},
+			[][]string{nil, nil},
+		},
+		"MLAT six nm from ADS-B": {
+			[][]Observation{
+				{at("4ca1fa", 1000, 51, 0)},
+				{mlat},
+			},
+			[][]string{nil, {EventMLATDisagreement}},
+		},
+		"MLAT too old to compare": {
+			[][]Observation{
+				{at("4ca1fa", 1000, 51, 0)},
+				{func() Observation { o := mlat; o.Now = 1020; return o }()},
+			},
+			[][]string{nil, nil},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := detect(tt.cycles...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	d := NewDetector()
+	d.Detect([]Observation{withSpeed(at("4ca1fa", 1000, 51, 0), 400, nil)})
+	events := d.Detect([]Observation{withSpeed(at("4ca1fa", 1010, 52, 0), 400, nil)})
+
+	if len(events) != 1 {
+		t.Fatalf("events = %v, want one teleport", types(events))
+	}
+	e := events[0]
+	if e.Hex != "4ca1fa" || e.Receiver != "home" || e.Time != 1010 || *e.Lat != 52 {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Details["from_lat"] != 51.0 || e.Details["allowed_gs_kt"] != 700.0 {
+		t.Errorf("details = %v", e.Details)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	// Each hop is a teleport; only those 300 s after the last one reported
+	// are emitted
+	hops := []struct {
+		now  float64
+		lat  float64
+		want []string
+	}{
+		{1000, 51, nil},
+		{1010, 52, []string{EventTeleport}},
+		{1020, 51, nil},
+		{1300, 52, nil},
+		{1310, 51, []string{EventTeleport}},
+	}
+
+	d := NewDetector()
+	for _, hop := range hops {
+		got := types(d.Detect([]Observation{withSpeed(at("4ca1fa", hop.now, hop.lat, 0), 400, nil)}))
+		if !reflect.DeepEqual(got, hop.want) {
+			t.Errorf("at %v: events = %v, want %v", hop.now, got, hop.want)
+		}
+	}
+
+	// The cooldown is per type: a climb_rate is still reported
+	d.Detect([]Observation{climbing("4ca1fa", 1320, 10000, 0)})
+	if got := types(d.Detect([]Observation{climbing("4ca1fa", 1330, 15000, 0)})); !reflect.DeepEqual(got, []string{EventClimbRate}) {
+		t.Errorf("events = %v, want a climb_rate during the teleport cooldown", got)
+	}
+}
+
+func reporting(now float64, nic, nacp int, hexes ...string) []Observation {
+	var observations []Observation
+	for i, hex := range hexes {
+		o := at(hex, now, 51.2+float64(i)*0.1, 0.3)
+		o.Aircraft.Nic, o.Aircraft.NacP = models.Ptr(nic), models.Ptr(nacp)
+		observations = append(observations, o)
+	}
+	return observations
+}
+
+func TestGPSJamming(t *testing.T) {
+	hexes := []string{"4ca1fa", "400a0b", "3c6444", "a1b2c3"}
+
+	tests := map[string]struct {
+		cycles [][]Observation
+		want   [][]string
+	}{
+		"raised then cleared when integrity returns": {
+			[][]Observation{
+				reporting(1000, 8, 9, hexes...),
+				reporting(1010, 0, 0, hexes[:3]...),
+				reporting(1020, 0, 0, hexes[:3]...),
+				reporting(1030, 8, 9, hexes[:3]...),
+			},
+			[][]string{nil, {EventGPSJamming}, nil, {EventGPSJammingClear}},
+		},
+		// The cell empties, so it can no longer be judged
+		"cleared when the aircraft leave": {
+			[][]Observation{
+				reporting(1000, 8, 9, hexes...),
+				reporting(1010, 0, 0, hexes...),
+				nil,
+			},
+			[][]string{nil, {EventGPSJamming}, {EventGPSJammingClear}},
+		},
+		"too few aircraft": {
+			[][]Observation{
+				reporting(1000, 8, 9, hexes[:2]...),
+				reporting(1010, 0, 0, hexes[:2]...),
+			},
+			[][]string{nil, nil},
+		},
+		"never had good integrity": {
+			[][]Observation{
+				reporting(1000, 0, 0, hexes...),
+				reporting(1010, 0, 0, hexes...),
+			},
+			[][]string{nil, nil},
+		},
+		"good integrity too long ago": {
+			[][]Observation{
+				reporting(1000, 8, 9, hexes...),
+				reporting(1700, 0, 0, hexes...),
+			},
+			[][]string{nil, nil},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := detect(tt.cycles...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGPSJammingEvent(t *testing.T) {
+	d := NewDetector()
+	d.Detect(reporting(1000, 8, 9, "4ca1fa", "400a0b", "3c6444"))
+	events := d.Detect(reporting(1010, 0, 0, "4ca1fa", "400a0b", "3c6444"))
+
+	if len(events) != 1 {
+		t.Fatalf("events = %v, want one gps_jamming", types(events))
+	}
+	e := events[0]
+	// The event is placed at the centre of the 1° cell
+	if *e.Lat != 51.5 || *e.Lon != 0.5 || e.Time != 1010 {
+		t.Errorf("event at %v, %v, %v, want 51.5, 0.5, 1010", *e.Lat, *e.Lon, e.Time)
+	}
+	if !reflect.DeepEqual(e.Details["hexes"], []string{"3c6444", "400a0b", "4ca1fa"}) || e.Details["aircraft_degraded"] != 3 {
+		t.Errorf("details = %v", e.Details)
+	}
+}
@@ -0,0 +1,350 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const filePrefix = "adsb-"
+
+type Config struct {
+	Dir string
+	// Compression is gzip, zstd or none
+	Compression string
+	// Rotate starts a new file at every multiple of this interval
+	Rotate time.Duration
+	// MaxSize starts a new file once the current one has grown to this many
+	// bytes on disk. Zero means no limit.
+	MaxSize int64
+	// Retention deletes archive files last written longer ago than this.
+	// Zero keeps them forever.
+	Retention time.Duration
+	// Snapshots archives the decoded receiver documents, as well as the
+	// records made from them
+	Snapshots bool
+}
+
+// LoadConfig reads the sink configuration from ARCHIVE_* environment
+// variables. It returns nil when ARCHIVE_DIR is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("archive.LoadConfig")
+
+	config := &Config{
+		Dir:         os.Getenv("ARCHIVE_DIR"),
+		Compression: os.Getenv("ARCHIVE_COMPRESSION"),
+		Rotate:      time.Hour,
+		MaxSize:     100 << 20,
+		Snapshots:   os.Getenv("ARCHIVE_SNAPSHOTS") != "false",
+	}
+	if config.Dir == "" {
+		return nil, nil
+	}
+
+	if config.Compression == "" {
+		config.Compression = "gzip"
+	}
+	switch config.Compression {
+	case "gzip", "zstd", "none":
+	default:
+		return nil, fmt.Errorf("invalid ARCHIVE_COMPRESSION %q: must be gzip, zstd or none", config.Compression)
+	}
+	if rotate := os.Getenv("ARCHIVE_ROTATE_INTERVAL"); rotate != "" {
+		d, err := time.ParseDuration(rotate)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ARCHIVE_ROTATE_INTERVAL %q", rotate)
+		}
+		config.Rotate = d
+	}
+	if size := os.Getenv("ARCHIVE_MAX_SIZE_MB"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid ARCHIVE_MAX_SIZE_MB %q", size)
+		}
+		config.MaxSize = n << 20
+	}
+	if retention := os.Getenv("ARCHIVE_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ARCHIVE_RETENTION %q", retention)
+		}
+		config.Retention = d
+	}
+
+	logging.Debug("Archive sink configured", "dir", config.Dir, "compression", config.Compression, "rotate", config.Rotate, "max_size", config.MaxSize, "retention", config.Retention, "snapshots", config.Snapshots)
+	return config, nil
+}
+
+// Entry is one line of an archive file. Line is the record's JSON line as
+// is, so an archive can be replayed into any sink.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Kind     sink.Kind         `json:"kind"`
+	Receiver string            `json:"receiver,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Line     json.RawMessage   `json:"line"`
+}
+
+// Sink appends every record to NDJSON files in a directory, named after the
+// time they were started: adsb-20240501T130000Z.ndjson.gz. Files are rotated
+// on interval boundaries and when they reach the size limit, and deleted
+// once past retention. The compressor is flushed after every write, so a
+// crash loses nothing already written, although the last file then lacks
+// its compression trailer.
+type Sink struct {
+	config Config
+	tracer trace.Tracer
+
+	file   *os.File
+	out    *countingWriter
+	buf    *bufio.Writer
+	stream compressor
+	// period is the start of the rotation interval the file belongs to
+	period time.Time
+}
+
+// compressor is the compressing writer of the current file. gzip and zstd
+// writers both satisfy it.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func New(config Config) (*Sink, error) {
+	logging.DebugCall("archive.New", "dir", config.Dir, "compression", config.Compression)
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	s := &Sink{
+		config: config,
+		tracer: otel.Tracer("archive"),
+	}
+	s.prune()
+
+	logging.Info("Archive sink created", "dir", config.Dir, "compression", config.Compression, "rotate", config.Rotate)
+	return s, nil
+}
+
+// Write appends the records, rotating first if the file is due.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	_, span := s.tracer.Start(ctx, "archive.write",
+		trace.WithAttributes(attribute.Int("records_count", len(records))),
+	)
+	defer span.End()
+
+	logging.DebugCall("archive.Write", "records", len(records))
+
+	if err := s.rotateIfDue(time.Now()); err != nil {
+		span.RecordError(err)
+		logging.Error("Failed to rotate archive file", "error", err, "dir", s.config.Dir)
+		return err
+	}
+
+	for _, r := range records {
+		line, err := json.Marshal(Entry{
+			Time:     r.Timestamp.UTC(),
+			Kind:     r.Kind,
+			Receiver: r.Receiver,
+			Labels:   r.Labels,
+			Line:     json.RawMessage(r.Line),
+		})
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to marshal archive entry", "error", err, "kind", r.Kind)
+			continue
+		}
+		if _, err := s.stream.Write(append(line, '\n')); err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to write archive file", "error", err, "file", s.file.Name())
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if err := s.Flush(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	logging.Debug("Archived records", "records", len(records), "file", s.file.Name(), "bytes", s.out.n)
+	return nil
+}
+
+// rotateIfDue closes the current file when its interval has passed or it is
+// full, and opens the next one.
+func (s *Sink) rotateIfDue(now time.Time) error {
+	period := now.UTC().Truncate(s.config.Rotate)
+	if s.file != nil {
+		full := s.config.MaxSize > 0 && s.out.n >= s.config.MaxSize
+		if period.Equal(s.period) && !full {
+			return nil
+		}
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+		s.prune()
+	}
+	return s.open(period, now)
+}
+
+func (s *Sink) open(period, now time.Time) error {
+	ext := ".ndjson"
+	switch s.config.Compression {
+	case "gzip":
+		ext += ".gz"
+	case "zstd":
+		ext += ".zst"
+	}
+
+	// Files rotated for size within one interval are named after the time
+	// they were started instead, and numbered should that clash too
+	start := period
+	if period.Equal(s.period) {
+		start = now.UTC()
+	}
+	base := filePrefix + start.Format("20060102T150405Z")
+	name := filepath.Join(s.config.Dir, base+ext)
+	for n := 1; ; n++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = filepath.Join(s.config.Dir, fmt.Sprintf("%s-%d%s", base, n, ext))
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	out := &countingWriter{w: file}
+	buf := bufio.NewWriterSize(out, 64<<10)
+	var stream compressor
+	switch s.config.Compression {
+	case "gzip":
+		stream = gzip.NewWriter(buf)
+	case "zstd":
+		stream, err = zstd.NewWriter(buf)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+	default:
+		stream = nopCloser{buf}
+	}
+
+	s.file, s.out, s.buf, s.stream, s.period = file, out, buf, stream, period
+	logging.Info("Opened archive file", "file", name)
+	return nil
+}
+
+func (s *Sink) closeFile() error {
+	name := s.file.Name()
+	err := s.stream.Close()
+	if err == nil {
+		err = s.buf.Flush()
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	if err != nil {
+		logging.Error("Failed to close archive file", "error", err, "file", name)
+		return fmt.Errorf("failed to close archive file: %w", err)
+	}
+
+	logging.Info("Closed archive file", "file", name, "bytes", s.out.n)
+	return nil
+}
+
+// prune deletes archive files older than the retention period. Failures
+// are logged, as they don't stop anything being archived.
+func (s *Sink) prune() {
+	if s.config.Retention == 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		logging.Error("Failed to list archive directory", "error", err, "dir", s.config.Dir)
+		return
+	}
+
+	cutoff := time.Now().Add(-s.config.Retention)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), filePrefix) || !strings.Contains(e.Name(), ".ndjson") {
+			continue
+		}
+		path := filepath.Join(s.config.Dir, e.Name())
+		if s.file != nil && path == s.file.Name() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logging.Error("Failed to delete expired archive file", "error", err, "file", path)
+			continue
+		}
+		logging.Info("Deleted expired archive file", "file", path)
+	}
+}
+
+// Flush writes everything compressed so far to the file.
+func (s *Sink) Flush(ctx context.Context) error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.stream.Flush(); err != nil {
+		return fmt.Errorf("failed to flush archive: %w", err)
+	}
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush archive: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the current file.
+func (s *Sink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+// countingWriter counts the bytes written to the file, for size rotation.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type nopCloser struct {
+	*bufio.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package flightdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/airports"
+	"github.com/burnettdev/adsb2loki/pkg/anomaly"
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/phase"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+// detectAnomalies runs the anomaly detector over every valid report of the
+// cycle, before receivers are merged, so positions heard by different
+// receivers can be compared with each other.
+func (p *Poller) detectAnomalies(perReceiver [][]report) []models.Event {
+	if p.detector == nil {
+		return nil
+	}
+
+	logging.DebugCall("detectAnomalies", "receivers", len(perReceiver))
+
+	var observations []anomaly.Observation
+	for _, reports := range perReceiver {
+		for _, r := range reports {
+			observations = append(observations, anomaly.Observation{
+				Aircraft: r.aircraft,
+				Receiver: r.receiver.Name,
+				Now:      r.now,
+			})
+		}
+	}
+
+	return p.detector.Detect(observations)
+}
+
+// classifyPhases tags every report with its flight phase and returns a
+// phase_change event for each aircraft whose phase changed. With an airport
+// database, starting a takeoff from the ground or touching down also emits a
+// takeoff or landing event at the nearest airport.
+func (p *Poller) classifyPhases(reports []report) []models.Event {
+	logging.DebugCall("classifyPhases", "reports", len(reports))
+
+	var (
+		events []models.Event
+		latest float64
+	)
+	for i := range reports {
+		r := &reports[i]
+
+		current, previous, changed := p.phases.Update(r.aircraft, r.now)
+		r.phase = current
+		if changed {
+			event := models.NewEvent(phase.EventPhaseChange, r.now, r.aircraft)
+			event.Receiver = r.receiver.Name
+			event.Details = map[string]interface{}{
+				"from":      previous,
+				"to":        current,
+				"alt_baro":  r.aircraft.AltBaro,
+				"gs":        r.aircraft.Gs,
+				"baro_rate": r.aircraft.BaroRate,
+				"ground":    r.aircraft.Ground,
+			}
+			events = append(events, event)
+
+			if movement, ok := p.movement(r, previous, current); ok {
+				events = append(events, movement)
+			}
+		}
+
+		if r.now > latest {
+			latest = r.now
+		}
+	}
+
+	p.phases.Expire(latest)
+	return events
+}
+
+// detectPatterns adds every report to the pattern detector's track history
+// and returns the orbit, holding and survey events it recognised.
+func (p *Poller) detectPatterns(reports []report) []models.Event {
+	if p.patterns == nil {
+		return nil
+	}
+
+	logging.DebugCall("detectPatterns", "reports", len(reports))
+
+	var (
+		events []models.Event
+		latest float64
+	)
+	for _, r := range reports {
+		if event, ok := p.patterns.Update(r.aircraft, r.now); ok {
+			event.Receiver = r.receiver.Name
+			events = append(events, event)
+		}
+		if r.now > latest {
+			latest = r.now
+		}
+	}
+
+	p.patterns.Expire(latest)
+	return events
+}
+
+// watch tags every report matching the watchlist or flagged military or
+// interesting in dbFlags, and returns a spotted event for each such aircraft
+// seen for the first time this session.
+func (p *Poller) watch(reports []report) []models.Event {
+	logging.DebugCall("watch", "reports", len(reports))
+
+	var (
+		events []models.Event
+		latest float64
+	)
+	for i := range reports {
+		r := &reports[i]
+		if r.now > latest {
+			latest = r.now
+		}
+
+		tags := p.config.watchlist.Match(r.aircraft)
+		if len(tags) == 0 {
+			continue
+		}
+		r.watch = tags[0]
+
+		if event, ok := p.config.watchlist.Spot(r.aircraft, tags, r.now); ok {
+			event.Receiver = r.receiver.Name
+			events = append(events, event)
+		}
+	}
+
+	p.config.watchlist.Expire(latest)
+	return events
+}
+
+func (p *Poller) movement(r *report, previous, current phase.Phase) (models.Event, bool) {
+	if p.config.airports == nil {
+		return models.Event{}, false
+	}
+
+	var eventType string
+	switch {
+	case previous == phase.Ground && current == phase.Takeoff:
+		eventType = airports.EventTakeoff
+	case current == phase.Landing:
+		eventType = airports.EventLanding
+	default:
+		return models.Event{}, false
+	}
+
+	event, ok := p.config.airports.Movement(eventType, r.aircraft, r.now)
+	if !ok {
+		logging.Debug("No airport found for movement", "event", eventType, "hex", r.aircraft.Hex)
+		return models.Event{}, false
+	}
+	event.Receiver = r.receiver.Name
+
+	logging.Info("Airport movement detected", "event", eventType, "hex", r.aircraft.Hex, "flight", r.aircraft.Flight, "airport", event.Details["airport"], "runway", event.Details["runway"])
+	return event, true
+}
+
+// eventRecord builds the output record for an event. Events about an aircraft
+// carry the labels of the receiver that heard it.
+func (p *Poller) eventRecord(event models.Event) (sink.Record, error) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return sink.Record{}, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	labels := map[string]string{"service": "adsb_events"}
+	if receiver := p.receiver(event.Receiver); receiver != nil {
+		labels = receiver.labels("adsb_events")
+	}
+	labels["event"] = event.Type
+
+	return sink.Record{
+		Timestamp: time.Unix(int64(event.Time), 0),
+		Labels:    labels,
+		Line:      string(line),
+		Kind:      sink.KindEvent,
+		Receiver:  event.Receiver,
+		Event:     &event,
+	}, nil
+}
+
+func (p *Poller) receiver(name string) *Receiver {
+	for i := range p.config.Receivers {
+		if p.config.Receivers[i].Name == name {
+			return &p.config.Receivers[i]
+		}
+	}
+	return nil
+}
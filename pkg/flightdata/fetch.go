@@ -0,0 +1,242 @@
+package flightdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// fetchDump1090 fetches a receiver's aircraft document. readsb's protobuf
+// aircraft.pb and documents described by a JSON mapping are decoded into the
+// same model as dump1090-fa's aircraft.json.
+func fetchDump1090(ctx context.Context, receiver *Receiver) (*models.Dump1090fa, error) {
+	url := receiver.URL
+
+	body, contentType, err := fetchBody(ctx, url, receiver.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiver.mapping != nil {
+		logging.Debug("Decoding aircraft data with JSON mapping", "url", url, "mapping", receiver.Mapping)
+		data, err := receiver.mapping.Decode(body)
+		if err != nil {
+			logging.Error("Failed to map aircraft data", "error", err, "url", url)
+			return nil, err
+		}
+		return data, nil
+	}
+
+	if isProtobuf(url, contentType) {
+		logging.Debug("Decoding readsb protobuf aircraft data", "url", url, "bytes", len(body))
+		data, err := models.UnmarshalAircraftsUpdate(body)
+		if err != nil {
+			logging.Error("Failed to decode readsb protobuf data", "error", err, "url", url)
+			return nil, fmt.Errorf("failed to decode protobuf response: %w", err)
+		}
+		return data, nil
+	}
+
+	var data models.Dump1090fa
+	if err := json.Unmarshal(body, &data); err != nil {
+		logging.Error("Failed to decode aircraft data", "error", err, "url", url)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &data, nil
+}
+
+// fetchJSON GETs url and decodes the JSON response body into v.
+func fetchJSON(ctx context.Context, url string, headers map[string]string, v interface{}) error {
+	body, _, err := fetchBody(ctx, url, headers)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		logging.Error("Failed to decode aircraft data", "error", err, "url", url)
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// fetchBody GETs url with any extra request headers and returns the
+// decompressed response body and its content type. gzip and zstd are
+// negotiated with the server, and statically compressed files
+// (aircraft.json.gz, *.zst) are detected by their magic bytes.
+func fetchBody(ctx context.Context, url string, headers map[string]string) ([]byte, string, error) {
+	ctx, span := tracer.Start(ctx, "flightdata.fetch_http",
+		trace.WithAttributes(
+			attribute.String("http.url", redactURL(url)),
+			attribute.String("http.method", "GET"),
+		),
+	)
+	defer span.End()
+
+	logging.DebugCall("fetchBody", "url", url)
+
+	// Create HTTP request with context for automatic tracing via otelhttp
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		span.RecordError(err)
+		logging.Error("Failed to create HTTP request", "error", err, "url", url)
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "adsb2loki/1.0.0")
+	// Setting Accept-Encoding ourselves disables net/http's transparent gzip
+	// handling, so the body is decompressed in decompress below.
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	duration := time.Since(start)
+
+	urlAttr := metric.WithAttributes(attribute.String("http.url", redactURL(url)))
+	fetchDuration.Record(ctx, duration.Seconds(), urlAttr)
+
+	if err != nil {
+		span.RecordError(err)
+		fetchErrors.Add(ctx, 1, urlAttr)
+		logging.Error("Failed to fetch aircraft data", "error", err, "url", url, "duration_ms", duration.Milliseconds())
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	logging.DebugHTTP("GET", url, resp.StatusCode, duration)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err := &rateLimitError{status: resp.Status, retryAfter: retryAfter(resp.Header, time.Now())}
+		span.RecordError(err)
+		fetchErrors.Add(ctx, 1, urlAttr)
+		logging.Warn("Rate limited by source", "url", url, "retry_after", err.retryAfter)
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("HTTP request failed with status: %s", resp.Status)
+		span.RecordError(err)
+		fetchErrors.Add(ctx, 1, urlAttr)
+		logging.Error("HTTP request returned non-200 status", "status_code", resp.StatusCode, "status", resp.Status, "url", url)
+		return nil, "", err
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		fetchErrors.Add(ctx, 1, urlAttr)
+		logging.Error("Failed to read response body", "error", err, "url", url)
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	fetchSize.Record(ctx, int64(len(raw)), urlAttr)
+
+	encoding := resp.Header.Get("Content-Encoding")
+	body, err := decompress(raw, encoding)
+	if err != nil {
+		span.RecordError(err)
+		logging.Error("Failed to decompress response body", "error", err, "url", url, "content_encoding", encoding)
+		return nil, "", fmt.Errorf("failed to decompress response: %w", err)
+	}
+
+	span.SetAttributes(
+		attribute.String("http.content_encoding", encoding),
+		attribute.Int("http.response.size_bytes", len(raw)),
+		attribute.Int("http.response.decoded_size_bytes", len(body)),
+	)
+	logging.Debug("Response body read", "url", url, "content_encoding", encoding, "size_bytes", len(raw), "decoded_size_bytes", len(body))
+
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// redactURL strips the credentials a receiver URL may carry, in its userinfo
+// or as an API key in its query, before it is recorded on spans and metrics.
+func redactURL(raw string) string {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		// The request can't be made either, so nothing is lost
+		return "invalid"
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// rateLimitError is returned for a 429 response. retryAfter is how long the
+// source asked to be left alone, or zero when it didn't say.
+type rateLimitError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("HTTP request failed with status: %s", e.status)
+}
+
+// retryAfter reads the standard Retry-After header, as seconds or a date,
+// falling back to OpenSky's X-Rate-Limit-Retry-After-Seconds.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	if v := header.Get("X-Rate-Limit-Retry-After-Seconds"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func decompress(body []byte, encoding string) ([]byte, error) {
+	switch {
+	case encoding == "gzip" || bytes.HasPrefix(body, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case encoding == "zstd" || bytes.HasPrefix(body, zstdMagic):
+		r, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case encoding == "" || encoding == "identity":
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+}
+
+func isProtobuf(url, contentType string) bool {
+	return strings.HasSuffix(strings.SplitN(url, "?", 2)[0], ".pb") ||
+		strings.Contains(contentType, "protobuf")
+}
@@ -3,19 +3,27 @@ package flightdata
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 
+	"github.com/burnettdev/adsb2loki/pkg/anomaly"
+	"github.com/burnettdev/adsb2loki/pkg/geo"
 	"github.com/burnettdev/adsb2loki/pkg/logging"
-	"github.com/burnettdev/adsb2loki/pkg/loki"
+	"github.com/burnettdev/adsb2loki/pkg/metrics"
 	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/pattern"
+	"github.com/burnettdev/adsb2loki/pkg/phase"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+	"github.com/burnettdev/adsb2loki/pkg/validation"
 )
 
 var (
@@ -24,106 +32,481 @@ var (
 		Timeout:   30 * time.Second,
 	}
 	tracer = otel.Tracer("flightdata-client")
+	meter  = otel.Meter("flightdata-client")
+
+	// OpenTelemetry instruments, exported when OTEL_METRICS_ENABLED is set.
+	// Creating them on the global provider never fails.
+	cycleDuration, _ = meter.Float64Histogram("adsb2loki.cycle.duration",
+		metric.WithDescription("Duration of a full fetch and push cycle"), metric.WithUnit("s"))
+	cycleErrors, _ = meter.Int64Counter("adsb2loki.cycle.errors",
+		metric.WithDescription("Fetch and push cycles that failed"))
+	fetchDuration, _ = meter.Float64Histogram("adsb2loki.fetch.duration",
+		metric.WithDescription("Duration of HTTP requests to receivers"), metric.WithUnit("s"))
+	fetchSize, _ = meter.Int64Histogram("adsb2loki.fetch.response.size",
+		metric.WithDescription("Size of receiver responses as transferred"), metric.WithUnit("By"))
+	fetchErrors, _ = meter.Int64Counter("adsb2loki.fetch.errors",
+		metric.WithDescription("Failed HTTP requests to receivers"))
+	aircraftGauge, _ = meter.Int64Gauge("adsb2loki.aircraft",
+		metric.WithDescription("Aircraft written in the last cycle"))
+	invalidCounter, _ = meter.Int64Counter("adsb2loki.aircraft.invalid",
+		metric.WithDescription("Aircraft that failed validation"))
+	eventCounter, _ = meter.Int64Counter("adsb2loki.events",
+		metric.WithDescription("Events emitted, by type"))
+	recordCounter, _ = meter.Int64Counter("adsb2loki.records",
+		metric.WithDescription("Records written to the output sinks, by kind"))
+)
+
+const (
+	Band1090 = "1090"
+	Band978  = "978"
 )
 
-func FetchAndPushToLoki(ctx context.Context, lokiClient *loki.Client) error {
+// report is a normalized aircraft together with the receiver and band it was
+// heard on and the snapshot time it belongs to.
+type report struct {
+	aircraft models.Aircraft
+	receiver *Receiver
+	band     string
+	now      float64
+
+	// invalid lists why the aircraft failed validation, if it did
+	invalid []validation.Reason
+	phase   phase.Phase
+	// watch is the first watchlist tag the aircraft matched
+	watch string
+}
+
+// snapshot is a receiver's aircraft document for one band, decoded into the
+// dump1090-fa model but not yet validated.
+type snapshot struct {
+	receiver *Receiver
+	band     string
+	data     *models.Dump1090fa
+}
+
+// Poller fetches aircraft and receiver stats from every configured receiver
+// and writes them to a sink.
+type Poller struct {
+	config *Config
+
+	// statsEnd is the end of the last stats.json window pushed per receiver
+	statsEnd map[string]float64
+	// detector is nil unless anomaly detection is enabled
+	detector *anomaly.Detector
+	phases   *phase.Tracker
+	// patterns is nil unless pattern detection is enabled
+	patterns *pattern.Detector
+	// due is when each receiver may next be polled, for receivers with an
+	// interval or that were rate limited
+	due map[string]time.Time
+}
+
+func NewPoller(config *Config) *Poller {
+	logging.DebugCall("NewPoller", "receivers", len(config.Receivers), "merge", config.Merge.Enabled)
+
+	p := &Poller{
+		config:   config,
+		statsEnd: make(map[string]float64),
+		phases:   phase.NewTracker(),
+		due:      make(map[string]time.Time),
+	}
+	if config.Anomalies {
+		p.detector = anomaly.NewDetector()
+	}
+	if config.Patterns {
+		p.patterns = pattern.NewDetector()
+	}
+	return p
+}
+
+func (p *Poller) FetchAndPush(ctx context.Context, out sink.Sink) error {
 	ctx, span := tracer.Start(ctx, "flightdata.fetch_and_push",
 		trace.WithAttributes(
 			attribute.String("service", "adsb"),
+			attribute.Int("receivers.count", len(p.config.Receivers)),
+			attribute.Bool("receivers.merge", p.config.Merge.Enabled),
 		),
 	)
 	defer span.End()
 
-	logging.DebugCall("FetchAndPushToLoki")
+	logging.DebugCall("FetchAndPush")
 
-	flightDataURL := os.Getenv("FLIGHT_DATA_URL")
-	logging.Debug("Flight data URL configured", "url", flightDataURL)
-
-	span.SetAttributes(
-		attribute.String("http.url", flightDataURL),
-		attribute.String("http.method", "GET"),
-	)
+	start := time.Now()
+	defer func() {
+		cycleDuration.Record(ctx, time.Since(start).Seconds())
+	}()
 
-	// Create HTTP request with context for automatic tracing via otelhttp
-	req, err := http.NewRequestWithContext(ctx, "GET", flightDataURL, nil)
+	perReceiver, invalid, snapshots, err := p.fetchAll(ctx)
+	if errors.Is(err, errNothingDue) {
+		// Skipping the whole cycle rather than pushing an empty one keeps
+		// the detectors from seeing every aircraft disappear between polls
+		logging.Debug("No receiver due for polling, skipping cycle")
+		return nil
+	}
 	if err != nil {
 		span.RecordError(err)
-		logging.Error("Failed to create HTTP request", "error", err, "url", flightDataURL)
-		return fmt.Errorf("failed to create request: %w", err)
+		cycleErrors.Add(ctx, 1)
+		return err
 	}
-	req.Header.Set("User-Agent", "adsb2loki/1.0.0")
 
-	start := time.Now()
-	resp, err := httpClient.Do(req)
-	duration := time.Since(start)
+	logInvalid(invalid)
 
-	if err != nil {
-		span.RecordError(err)
-		logging.Error("Failed to fetch dump1090-fa data", "error", err, "url", flightDataURL, "duration_ms", duration.Milliseconds())
-		return fmt.Errorf("failed to fetch dump1090-fa data: %w", err)
+	events := p.detectAnomalies(perReceiver)
+
+	var reports []report
+	if p.config.Merge.Enabled {
+		reports = mergeReceivers(p.config.Merge.Strategy, perReceiver...)
+	} else {
+		for _, r := range perReceiver {
+			reports = append(reports, r...)
+		}
 	}
-	defer resp.Body.Close()
 
-	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
-	logging.DebugHTTP("GET", flightDataURL, resp.StatusCode, duration)
+	events = append(events, p.classifyPhases(reports)...)
+	events = append(events, p.detectPatterns(reports)...)
+	events = append(events, p.watch(reports)...)
 
-	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("HTTP request failed with status: %s", resp.Status)
-		span.RecordError(err)
-		logging.Error("HTTP request returned non-200 status", "status_code", resp.StatusCode, "status", resp.Status)
-		return err
+	if p.config.InvalidRecords == InvalidRoute {
+		reports = append(reports, invalid...)
+	}
+
+	p.coarsen(reports, events)
+
+	records := make([]sink.Record, 0, len(reports))
+	for i, r := range reports {
+		aircraft := r.aircraft
+		logging.Debug("Processing aircraft", "index", i, "hex", aircraft.Hex, "receiver", r.receiver.Name, "band", r.band, "flight", aircraft.Flight, "lat", models.Deref(aircraft.Lat), "lon", models.Deref(aircraft.Lon), "alt_baro", models.Deref(aircraft.AltBaro), "ground", aircraft.Ground)
+
+		record, err := r.record()
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to marshal aircraft data, skipping", "error", err, "aircraft_hex", aircraft.Hex, "receiver", r.receiver.Name)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	logging.Debug("Converted aircraft data to records", "records_count", len(records))
+
+	for _, event := range events {
+		logging.Debug("Processing event", "event", event.Type, "hex", event.Hex, "receiver", event.Receiver)
+
+		record, err := p.eventRecord(event)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to marshal event, skipping", "error", err, "event", event.Type, "aircraft_hex", event.Hex)
+			continue
+		}
+		records = append(records, record)
 	}
 
-	var data models.Dump1090fa
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+	records = append(records, p.fetchStats(ctx)...)
+
+	if p.config.Snapshots {
+		for _, s := range snapshots {
+			record, err := p.snapshotRecord(s)
+			if err != nil {
+				span.RecordError(err)
+				logging.Error("Failed to marshal snapshot, skipping", "error", err, "receiver", s.receiver.Name, "band", s.band)
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	if err := out.Write(ctx, records); err != nil {
 		span.RecordError(err)
-		logging.Error("Failed to decode dump1090-fa data", "error", err)
-		return fmt.Errorf("failed to decode dump1090-fa data: %w", err)
+		cycleErrors.Add(ctx, 1)
+		logging.Error("Failed to write records", "error", err, "records_count", len(records))
+		return fmt.Errorf("failed to write records: %w", err)
 	}
 
 	span.SetAttributes(
-		attribute.Int("aircraft.count", len(data.Aircraft)),
-		attribute.Int64("data.timestamp", int64(data.Now)),
-		attribute.Int("data.messages", data.Messages),
+		attribute.Int("aircraft.count", len(reports)),
+		attribute.Int("aircraft.invalid", len(invalid)),
+		attribute.Int("events.count", len(events)),
+		attribute.Int("records.written", len(records)),
+	)
+
+	aircraftGauge.Record(ctx, int64(len(reports)))
+	invalidCounter.Add(ctx, int64(len(invalid)))
+	for _, event := range events {
+		eventCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("event", event.Type)))
+	}
+	for _, r := range records {
+		recordCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("kind", string(r.Kind))))
+	}
+
+	logging.Info("Successfully fetched and pushed aircraft data", "aircraft_count", len(reports), "invalid_count", len(invalid), "events_count", len(events), "records_written", len(records))
+	return nil
+}
+
+// errNothingDue is returned by fetchAll when every receiver is waiting out
+// its interval or a rate limit.
+var errNothingDue = errors.New("no receiver due for polling")
+
+// minRateLimitWait is how long a rate limited receiver is left alone when
+// the source doesn't say and the receiver has no longer interval.
+const minRateLimitWait = time.Minute
+
+// fetchAll polls every receiver that is due concurrently. A receiver that
+// fails is logged and skipped; an error is only returned when all of them
+// failed. Aircraft that failed validation are returned separately, as are
+// the documents polled.
+func (p *Poller) fetchAll(ctx context.Context) ([][]report, []report, []snapshot, error) {
+	logging.DebugCall("fetchAll", "receivers", len(p.config.Receivers))
+
+	now := time.Now()
+	var due []*Receiver
+	for i := range p.config.Receivers {
+		r := &p.config.Receivers[i]
+		if now.Before(p.due[r.Name]) {
+			logging.Debug("Receiver not due for polling", "receiver", r.Name, "due", p.due[r.Name])
+			continue
+		}
+		due = append(due, r)
+	}
+	if len(due) == 0 {
+		return nil, nil, nil, errNothingDue
+	}
+
+	results := make([][]report, len(due))
+	invalid := make([][]report, len(due))
+	snapshots := make([][]snapshot, len(due))
+	errs := make([]error, len(due))
+
+	var wg sync.WaitGroup
+	for i := range due {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], invalid[i], snapshots[i], errs[i] = p.fetchReceiver(ctx, due[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var (
+		perReceiver  [][]report
+		allInvalid   []report
+		allSnapshots []snapshot
+		lastErr      error
 	)
+	for i, err := range errs {
+		r := due[i]
+		if r.interval > 0 {
+			p.due[r.Name] = now.Add(r.interval)
+		}
 
-	logging.Debug("Successfully parsed flight data", "aircraft_count", len(data.Aircraft), "timestamp", data.Now, "messages", data.Messages)
+		var limited *rateLimitError
+		if errors.As(err, &limited) {
+			wait := max(limited.retryAfter, r.interval)
+			if wait == 0 {
+				wait = minRateLimitWait
+			}
+			p.due[r.Name] = now.Add(wait)
+			logging.Warn("Receiver rate limited, pausing polls", "receiver", r.Name, "wait", wait)
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			logging.Error("Failed to fetch receiver data", "error", err, "receiver", r.Name)
+			lastErr = err
+			continue
+		}
+		perReceiver = append(perReceiver, results[i])
+		allInvalid = append(allInvalid, invalid[i]...)
+		allSnapshots = append(allSnapshots, snapshots[i]...)
+	}
 
-	entries := make([]loki.LogEntry, 0, len(data.Aircraft))
-	for i, aircraft := range data.Aircraft {
-		logging.Debug("Processing aircraft", "index", i, "hex", aircraft.Hex, "flight", aircraft.Flight, "lat", aircraft.Lat, "lon", aircraft.Lon, "alt_baro", aircraft.AltBaro.String())
+	if len(perReceiver) == 0 {
+		return nil, nil, nil, fmt.Errorf("failed to fetch data from any receiver: %w", lastErr)
+	}
 
-		aircraftJSON, err := json.Marshal(aircraft)
+	return perReceiver, allInvalid, allSnapshots, nil
+}
+
+// fetchReceiver polls both bands of a single receiver, validates them and
+// deduplicates aircraft heard on both. Invalid aircraft are returned
+// separately and take no part in the merge. Privacy is applied to each band
+// as soon as it is validated, before metrics, the merge or any log see it.
+func (p *Poller) fetchReceiver(ctx context.Context, receiver *Receiver) ([]report, []report, []snapshot, error) {
+	ctx, span := tracer.Start(ctx, "flightdata.fetch_receiver",
+		trace.WithAttributes(
+			attribute.String("receiver", receiver.Name),
+		),
+	)
+	defer span.End()
+
+	logging.DebugCall("fetchReceiver", "receiver", receiver.Name, "url", receiver.URL, "uat_url", receiver.UATURL)
+
+	var (
+		bands     [][]report
+		invalid   []report
+		snapshots []snapshot
+	)
+
+	if receiver.URL != "" {
+		data, err := fetchDump1090(ctx, receiver)
 		if err != nil {
-			logging.Error("Failed to marshal aircraft data", "error", err, "aircraft_hex", aircraft.Hex)
-			return fmt.Errorf("failed to marshal aircraft data: %w", err)
+			span.RecordError(err)
+			return nil, nil, nil, fmt.Errorf("failed to fetch dump1090-fa data: %w", err)
 		}
 
-		labels := map[string]string{
-			"service": "adsb",
+		span.SetAttributes(
+			attribute.Int("aircraft.count", len(data.Aircraft)),
+			attribute.Int64("data.timestamp", int64(data.Now)),
+			attribute.Int("data.messages", data.Messages),
+		)
+		logging.Debug("Successfully parsed flight data", "receiver", receiver.Name, "aircraft_count", len(data.Aircraft), "timestamp", data.Now, "messages", data.Messages)
+
+		valid, rejected := newReports(data.Aircraft, receiver, Band1090, data.Now)
+		valid, rejected = p.protect(valid), p.protect(rejected)
+		observeSnapshot(receiver, Band1090, valid, data.Messages, data.Now)
+		bands = append(bands, valid)
+		invalid = append(invalid, rejected...)
+		snapshots = append(snapshots, snapshot{receiver: receiver, band: Band1090, data: data})
+	}
+
+	if receiver.UATURL != "" {
+		var data models.Dump978
+		if err := fetchJSON(ctx, receiver.UATURL, receiver.Headers, &data); err != nil {
+			span.RecordError(err)
+			if len(bands) == 0 {
+				return nil, nil, nil, fmt.Errorf("failed to fetch dump978-fa data: %w", err)
+			}
+			// Keep pushing 1090ES traffic when only the UAT receiver is down
+			logging.Warn("Skipping UAT data for this cycle", "error", err, "receiver", receiver.Name, "url", receiver.UATURL)
+		} else {
+			span.SetAttributes(
+				attribute.Int("uat.aircraft.count", len(data.Aircraft)),
+				attribute.Int("uat.data.messages", data.Messages),
+			)
+			logging.Debug("Successfully parsed UAT data", "receiver", receiver.Name, "aircraft_count", len(data.Aircraft), "timestamp", data.Now, "messages", data.Messages)
+
+			aircraft := make([]models.Aircraft, 0, len(data.Aircraft))
+			for _, a := range data.Aircraft {
+				aircraft = append(aircraft, a.Normalize())
+			}
+			valid, rejected := newReports(aircraft, receiver, Band978, data.Now)
+			valid, rejected = p.protect(valid), p.protect(rejected)
+			observeSnapshot(receiver, Band978, valid, data.Messages, data.Now)
+			bands = append(bands, valid)
+			invalid = append(invalid, rejected...)
+			snapshots = append(snapshots, snapshot{
+				receiver: receiver,
+				band:     Band978,
+				data:     &models.Dump1090fa{Now: data.Now, Messages: data.Messages, Aircraft: aircraft},
+			})
 		}
+	}
 
-		entry := loki.LogEntry{
-			Timestamp: time.Unix(int64(data.Now), 0),
-			Labels:    labels,
-			Line:      string(aircraftJSON),
+	return mergeByAddress(bands...), invalid, snapshots, nil
+}
+
+func newReport(aircraft models.Aircraft, receiver *Receiver, band string, now float64) report {
+	// Sources without a receiver location (UAT, protobuf, remote APIs) don't
+	// report range and bearing, so derive them from the configured position
+	if receiver.HasLocation() && aircraft.RDst == nil && aircraft.HasPosition() {
+		aircraft.RDst = models.Ptr(geo.Distance(receiver.Lat, receiver.Lon, *aircraft.Lat, *aircraft.Lon))
+		aircraft.RDir = models.Ptr(geo.Bearing(receiver.Lat, receiver.Lon, *aircraft.Lat, *aircraft.Lon))
+	}
+
+	return report{
+		aircraft: aircraft,
+		receiver: receiver,
+		band:     band,
+		now:      now,
+	}
+}
+
+// observeSnapshot updates the live aircraft metrics from the valid aircraft of
+// one band's snapshot.
+func observeSnapshot(receiver *Receiver, band string, reports []report, messages int, now float64) {
+	aircraft := make([]models.Aircraft, 0, len(reports))
+	for _, r := range reports {
+		aircraft = append(aircraft, r.aircraft)
+	}
+	metrics.ObserveSnapshot(receiver.Name, band, aircraft, messages, now)
+}
+
+// snapshotRecord builds the record of a decoded snapshot. Private aircraft
+// are dropped or anonymized and positions coarsened as for every other
+// record, so archiving snapshots doesn't get around the privacy settings.
+// NaN and Inf, which JSON can't carry, are cleared.
+func (p *Poller) snapshotRecord(s snapshot) (sink.Record, error) {
+	data := *s.data
+	data.Aircraft = make([]models.Aircraft, 0, len(s.data.Aircraft))
+	for _, a := range s.data.Aircraft {
+		if !p.config.privacy.Apply(&a) {
+			continue
 		}
+		p.config.privacy.CoarsenAircraft(&a)
+		validation.ClearNaN(&a)
+		data.Aircraft = append(data.Aircraft, a)
+	}
 
-		entries = append(entries, entry)
+	line, err := json.Marshal(data)
+	if err != nil {
+		return sink.Record{}, fmt.Errorf("failed to marshal snapshot: %w", err)
 	}
 
-	logging.Debug("Converted aircraft data to Loki entries", "entries_count", len(entries))
+	labels := s.receiver.labels("adsb_snapshots")
+	labels["band"] = s.band
 
-	if err := lokiClient.PushLogs(ctx, entries); err != nil {
-		span.RecordError(err)
-		logging.Error("Failed to push logs to Loki", "error", err, "entries_count", len(entries))
-		return fmt.Errorf("failed to push logs to Loki: %w", err)
+	return sink.Record{
+		Timestamp: time.Unix(int64(data.Now), 0),
+		Labels:    labels,
+		Line:      string(line),
+		Kind:      sink.KindSnapshot,
+		Receiver:  s.receiver.Name,
+		Snapshot:  &data,
+	}, nil
+}
+
+func (r report) labels() map[string]string {
+	labels := r.receiver.labels("adsb")
+	labels["band"] = r.band
+	if r.invalid != nil {
+		labels["quality"] = "invalid"
+	}
+	if r.phase != phase.Unknown {
+		labels["phase"] = string(r.phase)
 	}
+	if r.watch != "" {
+		labels["watch"] = r.watch
+	}
+	return labels
+}
 
-	span.SetAttributes(
-		attribute.Int("loki.entries_pushed", len(entries)),
-	)
+// record builds the output record for the report. Invalid aircraft carry the
+// reasons they were rejected in an "invalid" field on the line.
+func (r report) record() (sink.Record, error) {
+	aircraft := r.aircraft
+	if r.invalid != nil {
+		reasons, err := json.Marshal(r.invalid)
+		if err != nil {
+			return sink.Record{}, fmt.Errorf("failed to marshal validation reasons: %w", err)
+		}
+		extra := make(map[string]json.RawMessage, len(aircraft.Extra)+1)
+		for k, v := range aircraft.Extra {
+			extra[k] = v
+		}
+		extra["invalid"] = reasons
+		aircraft.Extra = extra
+	}
 
-	logging.Info("Successfully fetched and pushed aircraft data", "aircraft_count", len(data.Aircraft), "entries_pushed", len(entries))
-	return nil
+	line, err := json.Marshal(aircraft)
+	if err != nil {
+		return sink.Record{}, fmt.Errorf("failed to marshal aircraft data: %w", err)
+	}
+
+	return sink.Record{
+		Timestamp: time.Unix(int64(r.now), 0),
+		Labels:    r.labels(),
+		Line:      string(line),
+		Kind:      sink.KindAircraft,
+		Receiver:  r.receiver.Name,
+		Aircraft:  &aircraft,
+	}, nil
 }
@@ -0,0 +1,75 @@
+package flightdata
+
+import (
+	"strings"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// mergeByAddress combines aircraft from several bands of one receiver,
+// keeping a single entry per address. When an address is heard on more than
+// one band the most recently seen report wins.
+func mergeByAddress(sources ...[]report) []report {
+	logging.DebugCall("mergeByAddress", "sources", len(sources))
+
+	return merge(sources, func(candidate, existing report) bool {
+		logging.Debug("Duplicate address across bands", "hex", candidate.aircraft.Hex, "band", candidate.band, "existing_band", existing.band)
+		return candidate.aircraft.Seen < existing.aircraft.Seen
+	})
+}
+
+// mergeReceivers deduplicates aircraft heard by several receivers, keeping
+// the report with the best position according to strategy.
+func mergeReceivers(strategy string, receivers ...[]report) []report {
+	logging.DebugCall("mergeReceivers", "receivers", len(receivers), "strategy", strategy)
+
+	return merge(receivers, func(candidate, existing report) bool {
+		return betterPosition(strategy, candidate, existing)
+	})
+}
+
+func merge(sources [][]report, better func(candidate, existing report) bool) []report {
+	merged := make([]report, 0)
+	index := make(map[string]int)
+
+	for _, source := range sources {
+		for _, r := range source {
+			key := strings.ToLower(r.aircraft.Hex)
+			i, exists := index[key]
+			if !exists {
+				index[key] = len(merged)
+				merged = append(merged, r)
+				continue
+			}
+
+			if better(r, merged[i]) {
+				merged[i] = r
+			}
+		}
+	}
+
+	return merged
+}
+
+// betterPosition reports whether candidate carries a better position than
+// existing. A report with a position always beats one without; between two
+// positions the freshest seen_pos or the highest NIC wins, falling back to
+// seen_pos on a NIC tie.
+func betterPosition(strategy string, candidate, existing report) bool {
+	c, e := candidate.aircraft, existing.aircraft
+
+	cHasPos := c.HasPosition()
+	eHasPos := e.HasPosition()
+
+	switch {
+	case cHasPos != eHasPos:
+		return cHasPos
+	case !cHasPos:
+		return c.Seen < e.Seen
+	case strategy == MergeByNIC && models.Deref(c.Nic) != models.Deref(e.Nic):
+		return models.Deref(c.Nic) > models.Deref(e.Nic)
+	default:
+		return models.Deref(c.SeenPos) < models.Deref(e.SeenPos)
+	}
+}
@@ -0,0 +1,33 @@
+package flightdata
+
+import (
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// protect drops or anonymizes private aircraft in one band's reports. It
+// runs as soon as a band is validated, before anything else looks at the
+// reports, so no metric, log, later stage or event sees the identity of a
+// private aircraft.
+func (p *Poller) protect(reports []report) []report {
+	kept := reports[:0]
+	for _, r := range reports {
+		if !p.config.privacy.Apply(&r.aircraft) {
+			logging.Debug("Dropping private aircraft", "receiver", r.receiver.Name, "band", r.band)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// coarsen snaps the positions of every report and event to the privacy grid,
+// just before they are turned into records.
+func (p *Poller) coarsen(reports []report, events []models.Event) {
+	for i := range reports {
+		p.config.privacy.CoarsenAircraft(&reports[i].aircraft)
+	}
+	for i := range events {
+		p.config.privacy.CoarsenEvent(&events[i])
+	}
+}
@@ -0,0 +1,258 @@
+package flightdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/airports"
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/mapping"
+	"github.com/burnettdev/adsb2loki/pkg/privacy"
+	"github.com/burnettdev/adsb2loki/pkg/watchlist"
+)
+
+const (
+	MergeBySeenPos = "seen_pos"
+	MergeByNIC     = "nic"
+)
+
+// Receiver is a single ADS-B station to poll.
+type Receiver struct {
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	UATURL   string            `json:"uat_url,omitempty"`
+	StatsURL string            `json:"stats_url,omitempty"`
+	Lat      float64           `json:"lat,omitempty"`
+	Lon      float64           `json:"lon,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// Mapping is the path to a JSON mapping file for sources that aren't
+	// shaped like dump1090-fa, such as OpenSky or other REST aggregators
+	Mapping string `json:"mapping,omitempty"`
+	// Headers are sent with every request, e.g. for API authentication
+	Headers map[string]string `json:"headers,omitempty"`
+	// Interval is the least time between two polls of the receiver, such as
+	// "5m" for rate limited APIs. Unset, it is polled every cycle.
+	Interval string `json:"interval,omitempty"`
+
+	mapping  *mapping.Mapping
+	interval time.Duration
+}
+
+// HasLocation reports whether the receiver position is configured.
+func (r Receiver) HasLocation() bool {
+	return r.Lat != 0 || r.Lon != 0
+}
+
+// labels returns the Loki stream labels for a line from this receiver.
+func (r *Receiver) labels(service string) map[string]string {
+	labels := make(map[string]string, len(r.Labels)+3)
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+
+	labels["service"] = service
+	labels["receiver"] = r.Name
+
+	return labels
+}
+
+type MergeConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+type Config struct {
+	Receivers []Receiver  `json:"receivers"`
+	Merge     MergeConfig `json:"merge"`
+	// InvalidRecords is "drop" (default) or "route" to push aircraft that
+	// fail validation to a separate quality="invalid" stream
+	InvalidRecords string `json:"invalid_records,omitempty"`
+	// Anomalies enables the kinematic and GPS jamming checks, pushed as
+	// events to the service="adsb_events" stream
+	Anomalies bool `json:"anomalies,omitempty"`
+	// Patterns enables orbit, holding and survey detection
+	Patterns bool `json:"patterns,omitempty"`
+	// AirportsCSV and RunwaysCSV are OurAirports airports.csv and
+	// runways.csv files, enabling takeoff and landing events
+	AirportsCSV string `json:"airports_csv,omitempty"`
+	RunwaysCSV  string `json:"runways_csv,omitempty"`
+	// Watchlist is a JSON file of aircraft to tag with a watch label
+	Watchlist string `json:"watchlist,omitempty"`
+	// Privacy drops or anonymizes PIA, LADD and blocklisted aircraft and
+	// coarsens positions
+	Privacy privacy.Config `json:"privacy"`
+	// Snapshots adds every decoded receiver document to the records, for
+	// sinks that archive them. It is set when such a sink is configured.
+	Snapshots bool `json:"-"`
+
+	airports  *airports.Database
+	watchlist *watchlist.Watchlist
+	privacy   *privacy.Filter
+}
+
+// LoadConfig reads the receiver list from the JSON file named by
+// RECEIVERS_CONFIG. Without it a single receiver is built from
+// FLIGHT_DATA_URL, FLIGHT_DATA_MAPPING, FLIGHT_DATA_INTERVAL, UAT_DATA_URL,
+// STATS_DATA_URL and the RECEIVER_* variables. INVALID_RECORDS, ANOMALY_DETECTION,
+// PATTERN_DETECTION, AIRPORTS_CSV, RUNWAYS_CSV, WATCHLIST_FILE and the
+// PRIVACY_* variables apply when the file doesn't set the matching option.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("LoadConfig")
+
+	var config Config
+
+	if path := os.Getenv("RECEIVERS_CONFIG"); path != "" {
+		logging.Debug("Loading receivers configuration", "path", path)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read receivers config: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse receivers config: %w", err)
+		}
+	} else {
+		receiver := Receiver{
+			Name:     getEnvOrDefault("RECEIVER_NAME", "default"),
+			URL:      os.Getenv("FLIGHT_DATA_URL"),
+			UATURL:   os.Getenv("UAT_DATA_URL"),
+			StatsURL: os.Getenv("STATS_DATA_URL"),
+			Mapping:  os.Getenv("FLIGHT_DATA_MAPPING"),
+			Interval: os.Getenv("FLIGHT_DATA_INTERVAL"),
+		}
+		if lat, err := strconv.ParseFloat(os.Getenv("RECEIVER_LAT"), 64); err == nil {
+			receiver.Lat = lat
+		}
+		if lon, err := strconv.ParseFloat(os.Getenv("RECEIVER_LON"), 64); err == nil {
+			receiver.Lon = lon
+		}
+		config.Receivers = []Receiver{receiver}
+	}
+
+	if config.InvalidRecords == "" {
+		config.InvalidRecords = getEnvOrDefault("INVALID_RECORDS", InvalidDrop)
+	}
+	if !config.Anomalies {
+		config.Anomalies = os.Getenv("ANOMALY_DETECTION") == "true"
+	}
+	if !config.Patterns {
+		config.Patterns = os.Getenv("PATTERN_DETECTION") == "true"
+	}
+	if config.AirportsCSV == "" {
+		config.AirportsCSV = os.Getenv("AIRPORTS_CSV")
+	}
+	if config.RunwaysCSV == "" {
+		config.RunwaysCSV = os.Getenv("RUNWAYS_CSV")
+	}
+	if config.Watchlist == "" {
+		config.Watchlist = os.Getenv("WATCHLIST_FILE")
+	}
+	if config.Privacy.Mode == "" {
+		config.Privacy.Mode = getEnvOrDefault("PRIVACY_MODE", privacy.ModeOff)
+	}
+	if config.Privacy.Blocklist == "" {
+		config.Privacy.Blocklist = os.Getenv("PRIVACY_BLOCKLIST")
+	}
+	if config.Privacy.Salt == "" {
+		config.Privacy.Salt = os.Getenv("PRIVACY_SALT")
+	}
+	if config.Privacy.Grid == 0 {
+		if grid, err := strconv.ParseFloat(os.Getenv("PRIVACY_GRID"), 64); err == nil {
+			config.Privacy.Grid = grid
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	logging.Debug("Receivers configured", "count", len(config.Receivers), "merge", config.Merge.Enabled, "strategy", config.Merge.Strategy, "invalid_records", config.InvalidRecords, "anomalies", config.Anomalies, "patterns", config.Patterns, "privacy", config.Privacy.Mode, "privacy_grid", config.Privacy.Grid)
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Receivers) == 0 {
+		return fmt.Errorf("no receivers configured")
+	}
+
+	if c.Merge.Strategy == "" {
+		c.Merge.Strategy = MergeBySeenPos
+	}
+	if c.Merge.Strategy != MergeBySeenPos && c.Merge.Strategy != MergeByNIC {
+		return fmt.Errorf("unknown merge strategy %q", c.Merge.Strategy)
+	}
+
+	if c.InvalidRecords != InvalidDrop && c.InvalidRecords != InvalidRoute {
+		return fmt.Errorf("unknown invalid_records mode %q", c.InvalidRecords)
+	}
+
+	names := make(map[string]bool)
+	for i, r := range c.Receivers {
+		if r.Name == "" {
+			return fmt.Errorf("receiver %d has no name", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate receiver name %q", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.URL == "" && r.UATURL == "" {
+			return fmt.Errorf("receiver %q has no url or uat_url", r.Name)
+		}
+
+		if r.Interval != "" {
+			d, err := time.ParseDuration(r.Interval)
+			if err != nil || d < 0 {
+				return fmt.Errorf("receiver %q: invalid interval %q", r.Name, r.Interval)
+			}
+			c.Receivers[i].interval = d
+		}
+
+		if r.Mapping != "" {
+			m, err := mapping.Load(r.Mapping)
+			if err != nil {
+				return fmt.Errorf("receiver %q: %w", r.Name, err)
+			}
+			c.Receivers[i].mapping = m
+		}
+	}
+
+	if c.RunwaysCSV != "" && c.AirportsCSV == "" {
+		return fmt.Errorf("runways_csv needs airports_csv")
+	}
+	if c.AirportsCSV != "" {
+		db, err := airports.Load(c.AirportsCSV, c.RunwaysCSV)
+		if err != nil {
+			return err
+		}
+		c.airports = db
+	}
+
+	// Without a file, aircraft are still tagged from their dbFlags
+	var err error
+	if c.Watchlist != "" {
+		c.watchlist, err = watchlist.Load(c.Watchlist)
+	} else {
+		c.watchlist, err = watchlist.New(nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.privacy, err = privacy.New(c.Privacy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,83 @@
+package flightdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/metrics"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+// statsLine is the line for one stats.json period.
+type statsLine struct {
+	Period string `json:"period"`
+	models.StatsPeriod
+}
+
+// fetchStats polls stats.json for every receiver that has one and returns a
+// record for each receiver whose last1min window has moved on since the
+// previous poll. stats.json only changes once a minute, so unchanged windows
+// are not pushed again.
+func (p *Poller) fetchStats(ctx context.Context) []sink.Record {
+	ctx, span := tracer.Start(ctx, "flightdata.fetch_stats")
+	defer span.End()
+
+	logging.DebugCall("fetchStats")
+
+	var records []sink.Record
+	for i := range p.config.Receivers {
+		receiver := &p.config.Receivers[i]
+		if receiver.StatsURL == "" {
+			continue
+		}
+
+		var stats models.Dump1090faStats
+		if err := fetchJSON(ctx, receiver.StatsURL, receiver.Headers, &stats); err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to fetch receiver stats", "error", err, "receiver", receiver.Name, "url", receiver.StatsURL)
+			continue
+		}
+
+		period := stats.Last1Min
+		if p.statsEnd[receiver.Name] == period.End {
+			logging.Debug("Receiver stats unchanged, skipping", "receiver", receiver.Name, "end", period.End)
+			continue
+		}
+		p.statsEnd[receiver.Name] = period.End
+
+		metrics.ObserveReceiverStats(receiver.Name, period)
+
+		record, err := newStatsRecord(receiver, period)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to marshal receiver stats", "error", err, "receiver", receiver.Name)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	span.SetAttributes(attribute.Int("stats.records", len(records)))
+	return records
+}
+
+func newStatsRecord(receiver *Receiver, period models.StatsPeriod) (sink.Record, error) {
+	line, err := json.Marshal(statsLine{Period: "last1min", StatsPeriod: period})
+	if err != nil {
+		return sink.Record{}, fmt.Errorf("failed to marshal stats: %w", err)
+	}
+
+	return sink.Record{
+		Timestamp: time.Unix(int64(period.End), 0),
+		Labels:    receiver.labels("adsb_stats"),
+		Line:      string(line),
+		Kind:      sink.KindStats,
+		Receiver:  receiver.Name,
+		Stats:     &period,
+	}, nil
+}
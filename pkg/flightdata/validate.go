@@ -0,0 +1,53 @@
+package flightdata
+
+import (
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/metrics"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/validation"
+)
+
+// What to do with aircraft that fail validation.
+const (
+	InvalidDrop  = "drop"
+	InvalidRoute = "route"
+)
+
+// newReports validates one band's snapshot from a receiver and splits it
+// into reports to push and reports that failed validation. Every reason an
+// aircraft is rejected for is counted; the rejected aircraft themselves are
+// logged by logInvalid once privacy has been applied to them.
+func newReports(aircraft []models.Aircraft, receiver *Receiver, band string, now float64) ([]report, []report) {
+	logging.DebugCall("newReports", "receiver", receiver.Name, "band", band, "aircraft_count", len(aircraft))
+
+	reasons := validation.Snapshot(aircraft)
+
+	valid := make([]report, 0, len(aircraft))
+	var invalid []report
+	for i, a := range aircraft {
+		if len(reasons[i]) == 0 {
+			valid = append(valid, newReport(a, receiver, band, now))
+			continue
+		}
+
+		for _, reason := range reasons[i] {
+			metrics.ObserveInvalidAircraft(receiver.Name, string(reason))
+		}
+
+		// Routed records are encoded as JSON, which has no NaN or Inf
+		validation.ClearNaN(&a)
+		r := newReport(a, receiver, band, now)
+		r.invalid = reasons[i]
+		invalid = append(invalid, r)
+	}
+
+	return valid, invalid
+}
+
+// logInvalid logs the aircraft that failed validation. Privacy has already
+// been applied to them, so private aircraft are never logged by hex.
+func logInvalid(invalid []report) {
+	for _, r := range invalid {
+		logging.Debug("Aircraft failed validation", "receiver", r.receiver.Name, "band", r.band, "hex", r.aircraft.Hex, "reasons", r.invalid)
+	}
+}
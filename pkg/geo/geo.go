@@ -0,0 +1,54 @@
+package geo
+
+import "math"
+
+const earthRadiusNM = 3440.065
+
+// Distance returns the great-circle distance between two points in nautical
+// miles.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dPhi := radians(lat2 - lat1)
+	dLambda := radians(lon2 - lon1)
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusNM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Bearing returns the initial true bearing in degrees [0, 360) from the first
+// point to the second.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dLambda := radians(lon2 - lon1)
+
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return NormalizeHeading(degrees(math.Atan2(y, x)))
+}
+
+// NormalizeHeading wraps a heading into [0, 360).
+func NormalizeHeading(h float64) float64 {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// HeadingDifference returns the smallest angle in degrees [0, 180] between
+// two headings.
+func HeadingDifference(a, b float64) float64 {
+	d := NormalizeHeading(a - b)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
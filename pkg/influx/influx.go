@@ -0,0 +1,212 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const defaultBatchSize = 5000
+
+type Config struct {
+	// URL is the InfluxDB base URL, such as http://localhost:8086
+	URL       string
+	Token     string
+	Org       string
+	Bucket    string
+	Precision string
+	// BatchSize is the most lines sent in one write request
+	BatchSize int
+	Gzip      bool
+	Mapping   Mapping
+}
+
+// LoadConfig reads the sink configuration from INFLUX_* environment
+// variables. It returns nil when INFLUX_URL is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("influx.LoadConfig")
+
+	config := &Config{
+		URL:       strings.TrimSuffix(os.Getenv("INFLUX_URL"), "/"),
+		Token:     os.Getenv("INFLUX_TOKEN"),
+		Org:       os.Getenv("INFLUX_ORG"),
+		Bucket:    os.Getenv("INFLUX_BUCKET"),
+		Precision: os.Getenv("INFLUX_PRECISION"),
+		BatchSize: defaultBatchSize,
+		Gzip:      os.Getenv("INFLUX_GZIP") != "false",
+		Mapping:   DefaultMapping(),
+	}
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("INFLUX_BUCKET is required with INFLUX_URL")
+	}
+	if config.Precision == "" {
+		config.Precision = "s"
+	}
+	switch config.Precision {
+	case "s", "ms", "us", "ns":
+	default:
+		return nil, fmt.Errorf("invalid INFLUX_PRECISION %q: must be s, ms, us or ns", config.Precision)
+	}
+	if size := os.Getenv("INFLUX_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid INFLUX_BATCH_SIZE %q", size)
+		}
+		config.BatchSize = n
+	}
+	if path := os.Getenv("INFLUX_MAPPING"); path != "" {
+		mapping, err := LoadMapping(path)
+		if err != nil {
+			return nil, err
+		}
+		config.Mapping = mapping
+	}
+
+	logging.Debug("InfluxDB sink configured", "url", config.URL, "org", config.Org, "bucket", config.Bucket, "precision", config.Precision, "batch_size", config.BatchSize, "gzip", config.Gzip)
+	return config, nil
+}
+
+// Sink writes records as line protocol through the InfluxDB v2 HTTP write
+// API.
+type Sink struct {
+	config   Config
+	writeURL string
+	client   *http.Client
+	tracer   trace.Tracer
+}
+
+func New(config Config) *Sink {
+	logging.DebugCall("influx.New", "url", config.URL, "bucket", config.Bucket)
+
+	query := url.Values{}
+	query.Set("org", config.Org)
+	query.Set("bucket", config.Bucket)
+	query.Set("precision", config.Precision)
+
+	logging.Info("InfluxDB sink created", "url", config.URL, "bucket", config.Bucket)
+	return &Sink{
+		config:   config,
+		writeURL: config.URL + "/api/v2/write?" + query.Encode(),
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second,
+		},
+		tracer: otel.Tracer("influx-client"),
+	}
+}
+
+// Write encodes the mapped records and sends them in batches of at most
+// BatchSize lines.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	ctx, span := s.tracer.Start(ctx, "influx.write",
+		trace.WithAttributes(attribute.Int("records_count", len(records))),
+	)
+	defer span.End()
+
+	logging.DebugCall("influx.Write", "records", len(records))
+
+	var lines []string
+	for _, r := range records {
+		line, ok, err := s.config.Mapping.line(r, s.config.Precision)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to encode record as line protocol", "error", err, "kind", r.Kind)
+			continue
+		}
+		if ok {
+			lines = append(lines, line)
+		}
+	}
+	span.SetAttributes(attribute.Int("lines_count", len(lines)))
+
+	for start := 0; start < len(lines); start += s.config.BatchSize {
+		end := min(start+s.config.BatchSize, len(lines))
+		if err := s.post(ctx, lines[start:end]); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	logging.Debug("Successfully wrote lines to InfluxDB", "lines", len(lines))
+	return nil
+}
+
+func (s *Sink) post(ctx context.Context, lines []string) error {
+	var body bytes.Buffer
+	payload := []byte(strings.Join(lines, "\n"))
+	if s.config.Gzip {
+		zw := gzip.NewWriter(&body)
+		if _, err := zw.Write(payload); err != nil {
+			return fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress payload: %w", err)
+		}
+	} else {
+		body.Write(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.writeURL, &body)
+	if err != nil {
+		logging.Error("Failed to create HTTP request", "error", err, "url", s.writeURL)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "adsb2loki/1.0.0")
+	if s.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.config.Token)
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		logging.Error("HTTP request failed", "error", err, "url", s.config.URL, "duration_ms", duration.Milliseconds())
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	logging.DebugHTTP("POST", s.writeURL, resp.StatusCode, duration, "lines", len(lines), "bytes", body.Len())
+
+	if resp.StatusCode >= 400 {
+		// InfluxDB explains rejected lines in a JSON message
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		logging.Error("InfluxDB write failed", "status", resp.Status, "message", string(message))
+		return fmt.Errorf("write failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Flush does nothing, as Write sends straight away.
+func (s *Sink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections to InfluxDB.
+func (s *Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
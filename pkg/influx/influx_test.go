@@ -0,0 +1,241 @@
+package influx
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+var at = time.Unix(1700000000, 0)
+
+func aircraftRecord(hex, flight string) sink.Record {
+	return sink.Record{
+		Timestamp: at,
+		Kind:      sink.KindAircraft,
+		Labels:    map[string]string{"receiver": "home", "band": "1090"},
+		Line:      `{"hex":"` + hex + `","flight":"` + flight + `","lat":51.5,"lon":-0.12,"alt_baro":35000,"gs":450.5,"squawk":"7700"}`,
+	}
+}
+
+// server records the bodies of the write requests it receives, answering
+// them with status.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	bodies   []string
+	requests []*http.Request
+}
+
+func newServer(t *testing.T, status int, message string) *server {
+	t.Helper()
+
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(data))
+		s.requests = append(s.requests, r)
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+		io.WriteString(w, message)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newSink(url string, batchSize int, gzip bool) *Sink {
+	return New(Config{
+		URL:       url,
+		Token:     "secret",
+		Org:       "org",
+		Bucket:    "adsb",
+		Precision: "s",
+		BatchSize: batchSize,
+		Gzip:      gzip,
+		Mapping:   DefaultMapping(),
+	})
+}
+
+func TestLine(t *testing.T) {
+	tests := map[string]struct {
+		record sink.Record
+		want   string
+	}{
+		"aircraft": {
+			record: aircraftRecord("4ca1fa", "RYR1AB  "),
+			want:   `aircraft,band=1090,hex=4ca1fa,receiver=home flight="RYR1AB",lat=51.5,lon=-0.12,alt_baro=35000,gs=450.5,squawk="7700" 1700000000`,
+		},
+		"phase label as a field": {
+			record: func() sink.Record {
+				r := aircraftRecord("4ca1fa", "RYR1AB")
+				r.Labels["phase"] = "cruise"
+				return r
+			}(),
+			want: `aircraft,band=1090,hex=4ca1fa,receiver=home flight="RYR1AB",phase="cruise",lat=51.5,lon=-0.12,alt_baro=35000,gs=450.5,squawk="7700" 1700000000`,
+		},
+		"escaped tags and strings": {
+			record: func() sink.Record {
+				r := aircraftRecord("4ca1fa", `A \"B\"`)
+				r.Labels["receiver"] = "my home,1=a"
+				return r
+			}(),
+			want: `aircraft,band=1090,hex=4ca1fa,receiver=my\ home\,1\=a flight="A \"B\"",lat=51.5,lon=-0.12,alt_baro=35000,gs=450.5,squawk="7700" 1700000000`,
+		},
+		"nested stats": {
+			record: sink.Record{
+				Timestamp: at,
+				Kind:      sink.KindStats,
+				Labels:    map[string]string{"receiver": "home"},
+				Line:      `{"messages":12,"local":{"signal":-12.5,"noise":-30},"cpu":{"demod":1500}}`,
+			},
+			want: `receiver_stats,receiver=home messages=12,local_signal=-12.5,local_noise=-30,cpu_demod=1500 1700000000`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok, err := DefaultMapping().line(tt.record, "s")
+			if err != nil || !ok {
+				t.Fatalf("line() = %v, %v", ok, err)
+			}
+			if got != tt.want {
+				t.Errorf("line() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineSkipsUnmapped(t *testing.T) {
+	event := sink.Record{Timestamp: at, Kind: sink.KindEvent, Line: `{"event":"squawk_emergency"}`}
+	if _, ok, err := DefaultMapping().line(event, "s"); ok || err != nil {
+		t.Errorf("event line() = %v, %v, want no line", ok, err)
+	}
+
+	empty := sink.Record{Timestamp: at, Kind: sink.KindAircraft, Line: `{"hex":"4ca1fa"}`}
+	if _, ok, err := DefaultMapping().line(empty, "s"); ok || err != nil {
+		t.Errorf("line() without fields = %v, %v, want no line", ok, err)
+	}
+}
+
+func TestEscapeFieldString(t *testing.T) {
+	got, ok := fieldValue(`say "hi" \ bye`)
+	if want := `"say \"hi\" \\ bye"`; !ok || got != want {
+		t.Errorf("fieldValue() = %s, want %s", got, want)
+	}
+	if got := escape("air craft,x", ", "); got != `air\ craft\,x` {
+		t.Errorf("escape() = %s", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent, "")
+	s := newSink(srv.URL, 5000, true)
+
+	if err := s.Write(context.Background(), []sink.Record{aircraftRecord("4ca1fa", "RYR1AB")}); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if len(srv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(srv.requests))
+	}
+
+	r := srv.requests[0]
+	if r.URL.Path != "/api/v2/write" {
+		t.Errorf("path = %s", r.URL.Path)
+	}
+	q := r.URL.Query()
+	if q.Get("org") != "org" || q.Get("bucket") != "adsb" || q.Get("precision") != "s" {
+		t.Errorf("query = %s", r.URL.RawQuery)
+	}
+	if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := r.Header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if !strings.HasPrefix(srv.bodies[0], "aircraft,band=1090,hex=4ca1fa,receiver=home flight=\"RYR1AB\",") {
+		t.Errorf("body = %s", srv.bodies[0])
+	}
+}
+
+func TestWriteUncompressed(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent, "")
+	s := newSink(srv.URL, 5000, false)
+
+	if err := s.Write(context.Background(), []sink.Record{aircraftRecord("4ca1fa", "RYR1AB")}); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if got := srv.requests[0].Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent, "")
+	s := newSink(srv.URL, 2, true)
+
+	records := []sink.Record{
+		aircraftRecord("000001", "A"),
+		aircraftRecord("000002", "B"),
+		aircraftRecord("000003", "C"),
+		aircraftRecord("000004", "D"),
+		aircraftRecord("000005", "E"),
+	}
+	if err := s.Write(context.Background(), records); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	want := []int{2, 2, 1}
+	if len(srv.bodies) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(srv.bodies), len(want))
+	}
+	for i, body := range srv.bodies {
+		if n := len(strings.Split(body, "\n")); n != want[i] {
+			t.Errorf("request %d has %d lines, want %d", i, n, want[i])
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	srv := newServer(t, http.StatusBadRequest, `{"code":"invalid","message":"unable to parse line"}`)
+	s := newSink(srv.URL, 2, true)
+
+	records := []sink.Record{
+		aircraftRecord("000001", "A"),
+		aircraftRecord("000002", "B"),
+		aircraftRecord("000003", "C"),
+	}
+	err := s.Write(context.Background(), records)
+	if err == nil {
+		t.Fatal("Write() succeeded on a 400")
+	}
+	if !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "unable to parse line") {
+		t.Errorf("error %q lacks the status or InfluxDB's message", err)
+	}
+	// The first rejected batch stops the write, so the sink's retry resends
+	// everything
+	if len(srv.bodies) != 1 {
+		t.Errorf("got %d requests after a failure, want 1", len(srv.bodies))
+	}
+}
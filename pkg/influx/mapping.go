@@ -0,0 +1,225 @@
+package influx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+// Measurement maps one kind of record onto an InfluxDB measurement. Tags and
+// fields name Loki labels or fields of the JSON line; nested fields are
+// reached with dotted paths such as "local.signal", and their dots become
+// underscores in the key.
+type Measurement struct {
+	Name   string   `json:"measurement"`
+	Tags   []string `json:"tags,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Mapping holds the measurement for each record kind. Kinds without a
+// measurement name are not written.
+type Mapping map[sink.Kind]Measurement
+
+// DefaultMapping writes aircraft positions and receiver stats, but not events.
+func DefaultMapping() Mapping {
+	return Mapping{
+		sink.KindAircraft: {
+			Name: "aircraft",
+			// Callsigns and phases change over a flight, so as tags they would
+			// each start another series
+			Tags: []string{"receiver", "band", "hex", "category"},
+			Fields: []string{
+				"flight", "phase", "lat", "lon", "alt_baro", "alt_geom", "gs", "ias", "tas", "mach", "track",
+				"baro_rate", "geom_rate", "squawk", "rssi", "r_dst", "r_dir", "nic", "nac_p", "messages",
+			},
+		},
+		sink.KindStats: {
+			Name: "receiver_stats",
+			Tags: []string{"receiver"},
+			Fields: []string{
+				"messages", "local.signal", "local.noise", "local.peak_signal", "local.strong_signals",
+				"local.bad", "local.unknown_icao", "local.samples_dropped",
+				"cpr.global_ok", "cpr.global_bad", "cpr.local_ok", "cpr.filtered",
+				"tracks.all", "tracks.single_message",
+				"cpu.demod", "cpu.reader", "cpu.background",
+			},
+		},
+	}
+}
+
+// LoadMapping reads a JSON mapping from a file. Kinds it names replace those
+// of the default mapping; the others are kept.
+func LoadMapping(path string) (Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read InfluxDB mapping: %w", err)
+	}
+
+	var custom Mapping
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse InfluxDB mapping: %w", err)
+	}
+
+	mapping := DefaultMapping()
+	for kind, m := range custom {
+		switch kind {
+		case sink.KindAircraft, sink.KindEvent, sink.KindStats:
+		default:
+			return nil, fmt.Errorf("unknown record kind %q in InfluxDB mapping", kind)
+		}
+		mapping[kind] = m
+	}
+	return mapping, nil
+}
+
+// line encodes a record as a line of line protocol. It returns false when
+// the record's kind isn't mapped or none of the fields are present.
+func (m Mapping) line(r sink.Record, precision string) (string, bool, error) {
+	measurement, ok := m[r.Kind]
+	if !ok || measurement.Name == "" {
+		return "", false, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(r.Line)))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", false, fmt.Errorf("failed to decode %s record: %w", r.Kind, err)
+	}
+
+	var b strings.Builder
+	b.WriteString(escape(measurement.Name, ", "))
+
+	tags := make(map[string]string)
+	for _, path := range measurement.Tags {
+		value, ok := r.Labels[path]
+		if !ok {
+			value, ok = tagValue(lookup(doc, path))
+		}
+		if ok && value != "" {
+			tags[key(path)] = value
+		}
+	}
+	// Line protocol wants tags sorted by key for the best write performance
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("," + escape(k, ",= ") + "=" + escape(tags[k], ",= "))
+	}
+
+	fields := 0
+	for _, path := range measurement.Fields {
+		var v interface{}
+		if label, ok := r.Labels[path]; ok {
+			v = label
+		} else {
+			v = lookup(doc, path)
+		}
+		value, ok := fieldValue(v)
+		if !ok {
+			continue
+		}
+		if fields == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(escape(key(path), ",= ") + "=" + value)
+		fields++
+	}
+	if fields == 0 {
+		return "", false, nil
+	}
+
+	b.WriteString(" " + strconv.FormatInt(timestamp(r.Timestamp, precision), 10))
+	return b.String(), true, nil
+}
+
+// lookup follows a dotted path through a decoded JSON document.
+func lookup(doc map[string]interface{}, path string) interface{} {
+	var v interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+func tagValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// fieldValue formats a field. Numbers are always written as floats, so a
+// speed that happens to be whole in one snapshot doesn't conflict with the
+// float type of the field in the next.
+func fieldValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(strings.TrimSpace(v)) + `"`, true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+func key(path string) string {
+	return strings.ReplaceAll(path, ".", "_")
+}
+
+// escape backslash-escapes the given special characters, as line protocol
+// requires for measurements, tag keys, tag values and field keys.
+func escape(s, special string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c == '\n' {
+			c = ' '
+		}
+		if strings.ContainsRune(special, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func timestamp(t time.Time, precision string) int64 {
+	switch precision {
+	case "ms":
+		return t.UnixMilli()
+	case "us":
+		return t.UnixMicro()
+	case "ns":
+		return t.UnixNano()
+	default:
+		return t.Unix()
+	}
+}
@@ -0,0 +1,266 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const (
+	defaultBatchSize = 1000
+	// maxAttempts is how often the writer retries a batch itself, covering
+	// leader elections and other brief outages; longer ones are left to the
+	// sink queue
+	maxAttempts = 3
+)
+
+type Config struct {
+	Brokers []string
+	// Topic receives aircraft, and events and stats unless they have topics
+	// of their own
+	Topic       string
+	EventsTopic string
+	StatsTopic  string
+	// Compression is none, gzip, snappy, lz4 or zstd
+	Compression string
+	// Acks is all, one or none
+	Acks      string
+	BatchSize int
+
+	TLS bool
+	// SASLMechanism is plain, scram-sha-256 or scram-sha-512
+	SASLMechanism string
+	Username      string
+	Password      string
+}
+
+// LoadConfig reads the sink configuration from KAFKA_* environment
+// variables. It returns nil when KAFKA_BROKERS is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("kafka.LoadConfig")
+
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		return nil, nil
+	}
+
+	config := &Config{
+		Topic:         os.Getenv("KAFKA_TOPIC"),
+		EventsTopic:   os.Getenv("KAFKA_EVENTS_TOPIC"),
+		StatsTopic:    os.Getenv("KAFKA_STATS_TOPIC"),
+		Compression:   os.Getenv("KAFKA_COMPRESSION"),
+		Acks:          os.Getenv("KAFKA_ACKS"),
+		BatchSize:     defaultBatchSize,
+		TLS:           os.Getenv("KAFKA_TLS") == "true",
+		SASLMechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+		Username:      os.Getenv("KAFKA_USERNAME"),
+		Password:      os.Getenv("KAFKA_PASSWORD"),
+	}
+	for _, b := range strings.Split(brokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			config.Brokers = append(config.Brokers, b)
+		}
+	}
+	if config.Topic == "" {
+		config.Topic = "adsb"
+	}
+	if config.Compression == "" {
+		config.Compression = "none"
+	}
+	if config.Acks == "" {
+		config.Acks = "all"
+	}
+	if config.EventsTopic == "" {
+		config.EventsTopic = config.Topic
+	}
+	if config.StatsTopic == "" {
+		config.StatsTopic = config.Topic
+	}
+	if config.SASLMechanism == "" && config.Username != "" {
+		config.SASLMechanism = "plain"
+	}
+	if size := os.Getenv("KAFKA_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid KAFKA_BATCH_SIZE %q", size)
+		}
+		config.BatchSize = n
+	}
+
+	logging.Debug("Kafka sink configured", "brokers", config.Brokers, "topic", config.Topic, "events_topic", config.EventsTopic, "stats_topic", config.StatsTopic, "compression", config.Compression, "acks", config.Acks, "batch_size", config.BatchSize, "tls", config.TLS, "sasl", config.SASLMechanism)
+	return config, nil
+}
+
+// Sink produces every record as a message. Aircraft and events are keyed by
+// ICAO address, so all messages about an aircraft land on the same partition
+// in order; stats are keyed by receiver. Write returns once the brokers have
+// acknowledged every message, so a failed batch is retried by the sink queue
+// like any other. Delivery is at least once: a retried batch may repeat
+// messages that had already been written.
+type Sink struct {
+	writer *kafkago.Writer
+	config Config
+	tracer trace.Tracer
+}
+
+func New(config Config) (*Sink, error) {
+	logging.DebugCall("kafka.New", "brokers", config.Brokers, "topic", config.Topic)
+
+	var compression kafkago.Compression
+	switch config.Compression {
+	case "none", "":
+	case "gzip":
+		compression = kafkago.Gzip
+	case "snappy":
+		compression = kafkago.Snappy
+	case "lz4":
+		compression = kafkago.Lz4
+	case "zstd":
+		compression = kafkago.Zstd
+	default:
+		return nil, fmt.Errorf("unknown Kafka compression %q", config.Compression)
+	}
+
+	var acks kafkago.RequiredAcks
+	switch config.Acks {
+	case "all":
+		acks = kafkago.RequireAll
+	case "one":
+		acks = kafkago.RequireOne
+	case "none":
+		acks = kafkago.RequireNone
+	default:
+		return nil, fmt.Errorf("unknown Kafka acks %q: must be all, one or none", config.Acks)
+	}
+
+	transport := &kafkago.Transport{}
+	if config.TLS {
+		transport.TLS = &tls.Config{}
+	}
+	mechanism, err := config.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+
+	writer := &kafkago.Writer{
+		Addr: kafkago.TCP(config.Brokers...),
+		// The same partitioner as the Java client, so keys land where
+		// other producers would put them
+		Balancer:     &kafkago.Murmur2Balancer{},
+		RequiredAcks: acks,
+		Compression:  compression,
+		BatchSize:    config.BatchSize,
+		BatchTimeout: 50 * time.Millisecond,
+		MaxAttempts:  maxAttempts,
+		Transport:    transport,
+	}
+
+	logging.Info("Kafka sink created", "brokers", config.Brokers, "topic", config.Topic)
+	return &Sink{
+		writer: writer,
+		config: config,
+		tracer: otel.Tracer("kafka-client"),
+	}, nil
+}
+
+func (c Config) mechanism() (sasl.Mechanism, error) {
+	switch c.SASLMechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: c.Username, Password: c.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.Username, c.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.Username, c.Password)
+	default:
+		return nil, fmt.Errorf("unknown Kafka SASL mechanism %q", c.SASLMechanism)
+	}
+}
+
+// Write produces the records and waits for them to be acknowledged.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	ctx, span := s.tracer.Start(ctx, "kafka.write",
+		trace.WithAttributes(attribute.Int("records_count", len(records))),
+	)
+	defer span.End()
+
+	logging.DebugCall("kafka.Write", "records", len(records))
+
+	messages := make([]kafkago.Message, 0, len(records))
+	for _, r := range records {
+		messages = append(messages, s.message(r))
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	err := s.writer.WriteMessages(ctx, messages...)
+	duration := time.Since(start)
+	if err != nil {
+		span.RecordError(err)
+
+		var writeErrors kafkago.WriteErrors
+		if errors.As(err, &writeErrors) {
+			logging.Error("Kafka rejected some messages", "error", err, "failed", writeErrors.Count(), "messages", len(messages))
+			return fmt.Errorf("failed to produce %d of %d messages: %w", writeErrors.Count(), len(messages), err)
+		}
+		logging.Error("Failed to produce messages to Kafka", "error", err, "messages", len(messages), "duration_ms", duration.Milliseconds())
+		return fmt.Errorf("failed to produce messages: %w", err)
+	}
+
+	logging.Debug("Successfully produced messages to Kafka", "messages", len(messages), "duration_ms", duration.Milliseconds())
+	return nil
+}
+
+func (s *Sink) message(r sink.Record) kafkago.Message {
+	m := kafkago.Message{
+		Topic: s.config.Topic,
+		Value: []byte(r.Line),
+		Time:  r.Timestamp,
+		Headers: []kafkago.Header{
+			{Key: "kind", Value: []byte(r.Kind)},
+			{Key: "receiver", Value: []byte(r.Receiver)},
+		},
+	}
+	switch r.Kind {
+	case sink.KindAircraft:
+		m.Key = []byte(r.Aircraft.Hex)
+	case sink.KindEvent:
+		m.Topic = s.config.EventsTopic
+		m.Key = []byte(r.Event.Hex)
+		m.Headers = append(m.Headers, kafkago.Header{Key: "event", Value: []byte(r.Event.Type)})
+	case sink.KindStats:
+		m.Topic = s.config.StatsTopic
+		m.Key = []byte(r.Receiver)
+	}
+	return m
+}
+
+// Flush does nothing, as Write waits for every message to be acknowledged.
+func (s *Sink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the connections to the brokers.
+func (s *Sink) Close() error {
+	return s.writer.Close()
+}
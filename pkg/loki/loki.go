@@ -11,9 +11,25 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+var (
+	meter = otel.Meter("loki-client")
+
+	// Push instruments, exported with the others when OTEL_METRICS_ENABLED is set
+	pushDuration, _ = meter.Float64Histogram("adsb2loki.loki.push.duration",
+		metric.WithDescription("Duration of push requests to Loki"), metric.WithUnit("s"))
+	payloadSize, _ = meter.Int64Histogram("adsb2loki.loki.payload.size",
+		metric.WithDescription("Size of push request payloads"), metric.WithUnit("By"))
+	pushErrors, _ = meter.Int64Counter("adsb2loki.loki.push.errors",
+		metric.WithDescription("Push requests to Loki that failed"))
+	entriesPushed, _ = meter.Int64Counter("adsb2loki.loki.entries",
+		metric.WithDescription("Entries pushed to Loki"))
 )
 
 type Client struct {
@@ -102,6 +118,7 @@ func (c *Client) PushLogs(ctx context.Context, entries []LogEntry) error {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	payloadSize.Record(ctx, int64(len(data)))
 	span.SetAttributes(
 		attribute.Int("payload.size_bytes", len(data)),
 		attribute.Int("streams_count", len(streams)),
@@ -139,6 +156,8 @@ func (c *Client) PushLogs(ctx context.Context, entries []LogEntry) error {
 
 	if err != nil {
 		span.RecordError(err)
+		pushDuration.Record(ctx, duration.Seconds())
+		pushErrors.Add(ctx, 1)
 		logging.Error("HTTP request failed", "error", err, "url", url, "duration_ms", duration.Milliseconds())
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -147,11 +166,13 @@ func (c *Client) PushLogs(ctx context.Context, entries []LogEntry) error {
 	span.SetAttributes(
 		attribute.Int("http.status_code", resp.StatusCode),
 	)
+	pushDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.Int("http.status_code", resp.StatusCode)))
 	logging.DebugHTTP("POST", url, resp.StatusCode, duration, "entries_count", len(entries))
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		err := fmt.Errorf("authentication failed: %s", resp.Status)
 		span.RecordError(err)
+		pushErrors.Add(ctx, 1)
 		logging.Error("Authentication failed", "status", resp.Status, "tenant_id", c.tenantID)
 		return err
 	}
@@ -159,10 +180,37 @@ func (c *Client) PushLogs(ctx context.Context, entries []LogEntry) error {
 	if resp.StatusCode >= 400 {
 		err := fmt.Errorf("request failed with status: %s", resp.Status)
 		span.RecordError(err)
+		pushErrors.Add(ctx, 1)
 		logging.Error("HTTP request failed with bad status", "status", resp.Status, "status_code", resp.StatusCode)
 		return err
 	}
 
+	entriesPushed.Add(ctx, int64(len(entries)))
 	logging.Debug("Successfully pushed logs to Loki", "entries_count", len(entries), "status_code", resp.StatusCode)
 	return nil
 }
+
+// Write pushes the records as log entries, so the client can be used as a
+// sink.
+func (c *Client) Write(ctx context.Context, records []sink.Record) error {
+	entries := make([]LogEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, LogEntry{
+			Timestamp: r.Timestamp,
+			Labels:    r.Labels,
+			Line:      r.Line,
+		})
+	}
+	return c.PushLogs(ctx, entries)
+}
+
+// Flush does nothing, as Write pushes straight away.
+func (c *Client) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections to Loki.
+func (c *Client) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
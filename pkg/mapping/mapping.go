@@ -0,0 +1,400 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Mapping describes how to turn an arbitrary JSON document, such as an
+// OpenSky /states/all or ADSBExchange v2 response, into aircraft.
+type Mapping struct {
+	// Aircraft is the path to the list of aircraft in the document
+	Aircraft string `json:"aircraft"`
+	// Now is the optional path to the snapshot time
+	Now string `json:"now,omitempty"`
+	// NowUnit is "s" (default) or "ms"
+	NowUnit string `json:"now_unit,omitempty"`
+	// Passthrough copies every key of an aircraft object as-is before the
+	// field mappings are applied, for sources already shaped like readsb
+	Passthrough bool `json:"passthrough,omitempty"`
+	// Fields maps aircraft JSON field names (as in aircraft.json) to where
+	// their values are found within each aircraft. Names the aircraft model
+	// doesn't know are kept as extra fields on the line.
+	Fields map[string]Field `json:"fields"`
+}
+
+type Field struct {
+	Path      string   `json:"path"`
+	Unit      string   `json:"unit,omitempty"`
+	Scale     float64  `json:"scale,omitempty"`
+	Transform []string `json:"transform,omitempty"`
+}
+
+// Units the aircraft model is expressed in, by field.
+var fieldUnits = map[string]string{
+	"alt_baro":         "ft",
+	"alt_geom":         "ft",
+	"nav_altitude_mcp": "ft",
+	"nav_altitude_fms": "ft",
+	"gs":               "kt",
+	"ias":              "kt",
+	"tas":              "kt",
+	"ws":               "kt",
+	"baro_rate":        "ft/min",
+	"geom_rate":        "ft/min",
+	"r_dst":            "nm",
+}
+
+// Conversion factors to metres and metres per second.
+var (
+	lengthUnits = map[string]float64{
+		"m":  1,
+		"ft": 0.3048,
+		"km": 1000,
+		"nm": 1852,
+		"mi": 1609.344,
+	}
+	speedUnits = map[string]float64{
+		"m/s":    1,
+		"km/h":   1 / 3.6,
+		"kt":     1852.0 / 3600,
+		"mph":    1609.344 / 3600,
+		"ft/min": 0.3048 / 60,
+		"m/min":  1.0 / 60,
+	}
+)
+
+var aircraftKinds = fieldKinds(reflect.TypeOf(models.Aircraft{}))
+
+// Load reads a mapping definition from a JSON file.
+func Load(path string) (*Mapping, error) {
+	logging.DebugCall("mapping.Load", "path", path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping: %w", err)
+	}
+
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping: %w", err)
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping %s: %w", path, err)
+	}
+
+	logging.Debug("Mapping loaded", "path", path, "aircraft", m.Aircraft, "fields", len(m.Fields), "passthrough", m.Passthrough)
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	if m.NowUnit != "" && m.NowUnit != "s" && m.NowUnit != "ms" {
+		return fmt.Errorf("unknown now_unit %q", m.NowUnit)
+	}
+	if _, ok := m.Fields["hex"]; !ok && !m.Passthrough {
+		return fmt.Errorf("no hex field mapped")
+	}
+
+	for name, f := range m.Fields {
+		if f.Unit != "" {
+			// Without a model unit there is nothing to convert to, and a
+			// misspelled unit would go unnoticed
+			to, ok := fieldUnits[name]
+			if !ok {
+				return fmt.Errorf("field %q: has no unit to convert %s to", name, f.Unit)
+			}
+			if _, err := convert(0, f.Unit, to); err != nil {
+				return fmt.Errorf("field %q: %w", name, err)
+			}
+		}
+		for _, t := range f.Transform {
+			if _, ok := transforms[t]; !ok {
+				return fmt.Errorf("field %q: unknown transform %q", name, t)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decode maps a response document into the dump1090-fa snapshot model.
+func (m *Mapping) Decode(body []byte) (*models.Dump1090fa, error) {
+	logging.DebugCall("mapping.Decode", "bytes", len(body))
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	now := float64(time.Now().Unix())
+	if m.Now != "" {
+		if v, ok := toFloat(lookup(doc, m.Now)); ok {
+			now = v
+			if m.NowUnit == "ms" {
+				now /= 1000
+			}
+		}
+	}
+
+	list, ok := lookup(doc, m.Aircraft).([]interface{})
+	if !ok {
+		// OpenSky returns "states": null when nothing is in range
+		logging.Debug("No aircraft list found in response", "path", m.Aircraft)
+		return &models.Dump1090fa{Now: now}, nil
+	}
+
+	snapshot := &models.Dump1090fa{
+		Now:      now,
+		Aircraft: make([]models.Aircraft, 0, len(list)),
+	}
+
+	for i, item := range list {
+		aircraft, err := m.decodeAircraft(item, now)
+		if err != nil {
+			logging.Warn("Skipping unmappable aircraft", "error", err, "index", i)
+			continue
+		}
+		snapshot.Aircraft = append(snapshot.Aircraft, aircraft)
+	}
+
+	return snapshot, nil
+}
+
+func (m *Mapping) decodeAircraft(item interface{}, now float64) (models.Aircraft, error) {
+	var aircraft models.Aircraft
+
+	fields := make(map[string]interface{})
+	if obj, ok := item.(map[string]interface{}); ok && m.Passthrough {
+		for k, v := range obj {
+			fields[k] = v
+		}
+	}
+
+	for name, f := range m.Fields {
+		v := lookup(item, f.Path)
+		if v == nil {
+			delete(fields, name)
+			continue
+		}
+
+		v, err := f.apply(v, fieldUnits[name], now)
+		if err != nil {
+			return aircraft, fmt.Errorf("field %q: %w", name, err)
+		}
+		fields[name] = v
+	}
+
+	for name, v := range fields {
+		fields[name] = coerce(v, aircraftKinds[name])
+	}
+
+	hex, ok := fields["hex"].(string)
+	if !ok || hex == "" {
+		return aircraft, fmt.Errorf("missing hex")
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return aircraft, err
+	}
+	if err := json.Unmarshal(data, &aircraft); err != nil {
+		return aircraft, err
+	}
+	aircraft.Hex = models.NormalizeHex(aircraft.Hex, aircraft.Type)
+
+	return aircraft, nil
+}
+
+func (f Field) apply(v interface{}, unit string, now float64) (interface{}, error) {
+	if n, ok := toFloat(v); ok && (f.Unit != "" || f.Scale != 0) {
+		if f.Scale != 0 {
+			n *= f.Scale
+		}
+		if f.Unit != "" {
+			converted, err := convert(n, f.Unit, unit)
+			if err != nil {
+				return nil, err
+			}
+			// Keep the precision aircraft.json uses rather than conversion noise
+			n = math.Round(converted*100) / 100
+		}
+		v = n
+	}
+
+	for _, name := range f.Transform {
+		var err error
+		if v, err = transforms[name](v, now); err != nil {
+			return nil, fmt.Errorf("transform %s: %w", name, err)
+		}
+	}
+
+	return v, nil
+}
+
+var transforms = map[string]func(v interface{}, now float64) (interface{}, error){
+	"trim": func(v interface{}, _ float64) (interface{}, error) {
+		return strings.TrimSpace(toString(v)), nil
+	},
+	"lower": func(v interface{}, _ float64) (interface{}, error) {
+		return strings.ToLower(toString(v)), nil
+	},
+	"upper": func(v interface{}, _ float64) (interface{}, error) {
+		return strings.ToUpper(toString(v)), nil
+	},
+	"round": func(v interface{}, _ float64) (interface{}, error) {
+		n, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("not a number: %v", v)
+		}
+		return math.Round(n), nil
+	},
+	"negate": func(v interface{}, _ float64) (interface{}, error) {
+		n, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("not a number: %v", v)
+		}
+		return -n, nil
+	},
+	// age turns an absolute unix timestamp into seconds before the snapshot
+	"age": func(v interface{}, now float64) (interface{}, error) {
+		n, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("not a number: %v", v)
+		}
+		return math.Max(0, now-n), nil
+	},
+	// hex formats a numeric 24-bit address as six hex digits
+	"hex": func(v interface{}, _ float64) (interface{}, error) {
+		n, ok := v.(float64)
+		if !ok {
+			return toString(v), nil
+		}
+		return fmt.Sprintf("%06x", int64(n)&0xffffff), nil
+	},
+}
+
+// lookup resolves a dot-separated path, where numeric segments index arrays.
+// An empty path returns v itself.
+func lookup(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[segment]
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+
+	return v
+}
+
+func convert(v float64, from, to string) (float64, error) {
+	if from == to || to == "" {
+		return v, nil
+	}
+	if f, ok := lengthUnits[from]; ok {
+		if t, ok := lengthUnits[to]; ok {
+			return v * f / t, nil
+		}
+	}
+	if f, ok := speedUnits[from]; ok {
+		if t, ok := speedUnits[to]; ok {
+			return v * f / t, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+}
+
+// coerce adjusts a mapped value to the Go kind of the aircraft field so that
+// e.g. a converted altitude of 35999.99 still decodes into an int.
+func coerce(v interface{}, kind reflect.Kind) interface{} {
+	switch kind {
+	case reflect.Int, reflect.Int64:
+		if n, ok := toFloat(v); ok {
+			return int64(math.Round(n))
+		}
+	case reflect.Float64:
+		if n, ok := toFloat(v); ok {
+			return n
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok && v != nil {
+			if _, isNumber := toFloat(v); isNumber {
+				return toString(v)
+			}
+		}
+	}
+	return v
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// fieldKinds indexes the JSON field names of a struct by their Go kind.
+// Fields with their own UnmarshalJSON are left to decode the value themselves
+// and are indexed as reflect.Invalid.
+func fieldKinds(t reflect.Type) map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if reflect.PointerTo(ft).Implements(unmarshalerType) {
+			kinds[name] = reflect.Invalid
+			continue
+		}
+		kinds[name] = ft.Kind()
+	}
+	return kinds
+}
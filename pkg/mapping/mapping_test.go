@@ -0,0 +1,141 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// The fixtures in testdata are trimmed responses in the documented formats
+// of the OpenSky /states/all and ADSBExchange v2 APIs, decoded through the
+// mappings shipped in mappings/.
+
+func decodeFixture(t *testing.T, mapping, fixture string) *models.Dump1090fa {
+	t.Helper()
+
+	m, err := Load(filepath.Join("..", "..", "mappings", mapping))
+	if err != nil {
+		t.Fatalf("Load(%s): %v", mapping, err)
+	}
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := m.Decode(body)
+	if err != nil {
+		t.Fatalf("Decode(%s): %v", fixture, err)
+	}
+	return snapshot
+}
+
+func TestDecodeOpenSky(t *testing.T) {
+	snapshot := decodeFixture(t, "opensky.json", "opensky-states-all.json")
+
+	if snapshot.Now != 1700000000 {
+		t.Errorf("now = %v, want 1700000000", snapshot.Now)
+	}
+	// The state vector without an icao24 is skipped
+	if len(snapshot.Aircraft) != 2 {
+		t.Fatalf("got %d aircraft, want 2", len(snapshot.Aircraft))
+	}
+
+	a := snapshot.Aircraft[0]
+	if a.Hex != "4b1815" || a.Flight != "SWR123" || a.Squawk != "1000" {
+		t.Errorf("hex, flight, squawk = %q, %q, %q", a.Hex, a.Flight, a.Squawk)
+	}
+	if a.Lat == nil || *a.Lat != 47.4502 || a.Lon == nil || *a.Lon != 8.5417 {
+		t.Errorf("position = %v, %v", a.Lat, a.Lon)
+	}
+	if a.AltBaro == nil || *a.AltBaro != 35000 {
+		t.Errorf("alt_baro = %v, want 35000 ft", a.AltBaro)
+	}
+	if a.AltGeom == nil || *a.AltGeom != 36000 {
+		t.Errorf("alt_geom = %v, want 36000 ft", a.AltGeom)
+	}
+	if a.Gs == nil || *a.Gs != 450 {
+		t.Errorf("gs = %v, want 450 kt", a.Gs)
+	}
+	if a.BaroRate == nil || *a.BaroRate != 0 {
+		t.Errorf("baro_rate = %v, want 0", a.BaroRate)
+	}
+	if a.SeenPos == nil || *a.SeenPos != 2 || a.Seen != 1 {
+		t.Errorf("seen_pos, seen = %v, %v, want 2, 1", a.SeenPos, a.Seen)
+	}
+	if a.Ground {
+		t.Error("airborne aircraft decoded as on the ground")
+	}
+
+	g := snapshot.Aircraft[1]
+	if g.Hex != "3c6444" || !g.Ground || g.AltBaro != nil {
+		t.Errorf("hex, ground, alt_baro = %q, %v, %v, want 3c6444 on the ground", g.Hex, g.Ground, g.AltBaro)
+	}
+	if g.BaroRate != nil || g.AltGeom != nil {
+		t.Errorf("null state fields decoded: baro_rate %v, alt_geom %v", g.BaroRate, g.AltGeom)
+	}
+}
+
+func TestDecodeADSBExchangeV2(t *testing.T) {
+	snapshot := decodeFixture(t, "adsbexchange-v2.json", "adsbexchange-v2.json")
+
+	if snapshot.Now != 1700000000.123 {
+		t.Errorf("now = %v, want 1700000000.123", snapshot.Now)
+	}
+	if len(snapshot.Aircraft) != 2 {
+		t.Fatalf("got %d aircraft, want 2", len(snapshot.Aircraft))
+	}
+
+	a := snapshot.Aircraft[0]
+	if a.Hex != "a1b2c3" || a.Type != models.TypeADSBICAO {
+		t.Errorf("hex, type = %q, %q", a.Hex, a.Type)
+	}
+	if a.Flight != "UAL123  " || a.R != "N12345" || a.T != "B738" || !a.IsMilitary() {
+		t.Errorf("flight, r, t, military = %q, %q, %q, %v", a.Flight, a.R, a.T, a.IsMilitary())
+	}
+	if a.AltBaro == nil || *a.AltBaro != 37000 || a.Gs == nil || *a.Gs != 452.3 {
+		t.Errorf("alt_baro, gs = %v, %v", a.AltBaro, a.Gs)
+	}
+	if a.BaroRate == nil || *a.BaroRate != -64 || a.Messages != 12345 {
+		t.Errorf("baro_rate, messages = %v, %d", a.BaroRate, a.Messages)
+	}
+	// Unmapped fields are passed through as they are
+	if a.GpsOkBefore == nil || *a.GpsOkBefore != 1699999000.5 || len(a.Extra) != 0 {
+		t.Errorf("gpsOkBefore, extra = %v, %v", a.GpsOkBefore, a.Extra)
+	}
+
+	g := snapshot.Aircraft[1]
+	if g.Hex != "~2a3b4c" {
+		t.Errorf("hex = %q, want ~2a3b4c for a non-ICAO address", g.Hex)
+	}
+	if !g.Ground || g.AltBaro != nil {
+		t.Errorf("ground, alt_baro = %v, %v, want alt_baro \"ground\" decoded as on the ground", g.Ground, g.AltBaro)
+	}
+}
+
+func TestValidateRejectsUnits(t *testing.T) {
+	tests := map[string]struct {
+		field string
+		unit  string
+	}{
+		"unknown unit":          {"alt_baro", "metres"},
+		"unit across dimension": {"alt_baro", "m/s"},
+		"field without unit":    {"track", "deg"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := Mapping{Aircraft: "states", Fields: map[string]Field{
+				"hex":    {Path: "0"},
+				tt.field: {Path: "7", Unit: tt.unit},
+			}}
+			err := m.validate()
+			if err == nil {
+				t.Fatalf("validate() accepted unit %q on %s", tt.unit, tt.field)
+			}
+			if !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("error %q doesn't name the field %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+const namespace = "adsb2loki"
+
+var (
+	receiverLabels = []string{"receiver"}
+
+	receiverSignal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "signal_dbfs",
+		Help:      "Mean signal level of accepted messages over the last minute.",
+	}, receiverLabels)
+	receiverNoise = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "noise_dbfs",
+		Help:      "Noise floor over the last minute.",
+	}, receiverLabels)
+	receiverPeakSignal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "peak_signal_dbfs",
+		Help:      "Peak signal level over the last minute.",
+	}, receiverLabels)
+	receiverStrongSignals = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "strong_signals",
+		Help:      "Messages above -3 dBFS over the last minute.",
+	}, receiverLabels)
+	receiverMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "messages_last1min",
+		Help:      "Messages processed over the last minute.",
+	}, receiverLabels)
+	receiverAccepted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "accepted_messages_last1min",
+		Help:      "Mode S messages accepted over the last minute, by origin.",
+	}, []string{"receiver", "origin"})
+	receiverBad = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "bad_messages_last1min",
+		Help:      "Mode S preambles with bad messages over the last minute.",
+	}, receiverLabels)
+	receiverUnknownICAO = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "unknown_icao_last1min",
+		Help:      "Messages from unrecognised ICAO addresses over the last minute.",
+	}, receiverLabels)
+	receiverSamplesDropped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "samples_dropped_last1min",
+		Help:      "SDR samples dropped over the last minute.",
+	}, receiverLabels)
+	receiverCPR = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "cpr_decodes_last1min",
+		Help:      "CPR position decodes over the last minute, by result.",
+	}, []string{"receiver", "result"})
+	receiverTracks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "tracks_last1min",
+		Help:      "Aircraft tracks created over the last minute.",
+	}, receiverLabels)
+	receiverSingleMessageTracks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "tracks_single_message_last1min",
+		Help:      "Aircraft tracks with only a single message over the last minute.",
+	}, receiverLabels)
+
+	snapshotLabels = []string{"receiver", "band"}
+
+	aircraftTracked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "aircraft",
+		Name:      "tracked",
+		Help:      "Aircraft in the latest snapshot, by emitter category.",
+	}, []string{"receiver", "band", "category"})
+	aircraftBySource = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "aircraft",
+		Name:      "by_source",
+		Help:      "Aircraft in the latest snapshot, by data source (adsb, mlat, tisb, ...).",
+	}, []string{"receiver", "band", "source"})
+	aircraftWithPosition = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "aircraft",
+		Name:      "with_position",
+		Help:      "Aircraft in the latest snapshot with a decoded position.",
+	}, snapshotLabels)
+	aircraftMaxRange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "aircraft",
+		Name:      "max_range_nm",
+		Help:      "Distance of the furthest aircraft in the latest snapshot from the receiver.",
+	}, snapshotLabels)
+	aircraftRSSI = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "aircraft",
+		Name:      "rssi_dbfs",
+		Help:      "Signal level of every aircraft in every snapshot.",
+		Buckets:   prometheus.LinearBuckets(-48, 3, 16),
+	}, snapshotLabels)
+	messageRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "messages_per_second",
+		Help:      "Messages decoded per second between the last two snapshots.",
+	}, snapshotLabels)
+
+	// lastMessages is the message counter and time of the previous snapshot
+	// per receiver and band, to derive the message rate from
+	lastMessages   = make(map[[2]string][2]float64)
+	lastMessagesMu sync.Mutex
+
+	invalidAircraft = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_aircraft_total",
+		Help:      "Aircraft that failed validation, by reason.",
+	}, []string{"receiver", "reason"})
+
+	sinkLabels = []string{"sink"}
+
+	sinkQueue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sink",
+		Name:      "queued_batches",
+		Help:      "Batches waiting to be written to an output sink.",
+	}, sinkLabels)
+	sinkFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sink",
+		Name:      "write_failures_total",
+		Help:      "Failed attempts to write a batch to an output sink.",
+	}, sinkLabels)
+	sinkDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sink",
+		Name:      "dropped_records_total",
+		Help:      "Records never written to an output sink because its queue was full or it kept failing.",
+	}, sinkLabels)
+)
+
+// Serve exposes the default Prometheus registry on addr at /metrics. The
+// returned function stops the server.
+func Serve(addr string) (func(), error) {
+	logging.DebugCall("metrics.Serve", "addr", addr)
+
+	if addr == "" {
+		logging.Debug("Prometheus metrics endpoint is disabled")
+		return func() {}, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Error("Prometheus metrics server failed", "error", err, "addr", addr)
+		}
+	}()
+
+	logging.Info("Prometheus metrics endpoint started", "addr", addr, "path", "/metrics")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logging.Error("Error shutting down metrics server", "error", err)
+		}
+	}, nil
+}
+
+// ObserveReceiverStats updates the receiver health gauges from a stats.json
+// period.
+func ObserveReceiverStats(receiver string, period models.StatsPeriod) {
+	logging.DebugCall("ObserveReceiverStats", "receiver", receiver, "end", period.End)
+
+	local, remote := period.AcceptedTotal()
+
+	receiverSignal.WithLabelValues(receiver).Set(period.Local.Signal)
+	receiverNoise.WithLabelValues(receiver).Set(period.Local.Noise)
+	receiverPeakSignal.WithLabelValues(receiver).Set(period.Local.PeakSignal)
+	receiverStrongSignals.WithLabelValues(receiver).Set(float64(period.Local.StrongSignals))
+	receiverMessages.WithLabelValues(receiver).Set(float64(period.Messages))
+	receiverAccepted.WithLabelValues(receiver, "local").Set(float64(local))
+	receiverAccepted.WithLabelValues(receiver, "remote").Set(float64(remote))
+	receiverBad.WithLabelValues(receiver).Set(float64(period.Local.Bad))
+	receiverUnknownICAO.WithLabelValues(receiver).Set(float64(period.Local.UnknownICAO))
+	receiverSamplesDropped.WithLabelValues(receiver).Set(float64(period.Local.SamplesDropped))
+	receiverCPR.WithLabelValues(receiver, "global_ok").Set(float64(period.CPR.GlobalOK))
+	receiverCPR.WithLabelValues(receiver, "global_bad").Set(float64(period.CPR.GlobalBad))
+	receiverCPR.WithLabelValues(receiver, "local_ok").Set(float64(period.CPR.LocalOK))
+	receiverCPR.WithLabelValues(receiver, "filtered").Set(float64(period.CPR.Filtered))
+	receiverTracks.WithLabelValues(receiver).Set(float64(period.Tracks.All))
+	receiverSingleMessageTracks.WithLabelValues(receiver).Set(float64(period.Tracks.SingleMessage))
+}
+
+// ObserveSnapshot updates the live aircraft gauges from one receiver's
+// snapshot of a band. messages is the receiver's running message counter and
+// now the snapshot time.
+func ObserveSnapshot(receiver, band string, aircraft []models.Aircraft, messages int, now float64) {
+	logging.DebugCall("ObserveSnapshot", "receiver", receiver, "band", band, "aircraft", len(aircraft))
+
+	categories := make(map[string]int)
+	sources := make(map[models.Source]int)
+	var withPosition int
+	var maxRange float64
+	for _, a := range aircraft {
+		category := a.Category
+		if category == "" {
+			category = "unknown"
+		}
+		categories[category]++
+		sources[a.Source()]++
+
+		if a.HasPosition() {
+			withPosition++
+			maxRange = max(maxRange, models.Deref(a.RDst))
+		}
+		if a.Rssi != nil {
+			aircraftRSSI.WithLabelValues(receiver, band).Observe(*a.Rssi)
+		}
+	}
+
+	// Categories and sources that have disappeared must not keep their
+	// last count
+	snapshot := prometheus.Labels{"receiver": receiver, "band": band}
+	aircraftTracked.DeletePartialMatch(snapshot)
+	aircraftBySource.DeletePartialMatch(snapshot)
+	for category, n := range categories {
+		aircraftTracked.WithLabelValues(receiver, band, category).Set(float64(n))
+	}
+	for source, n := range sources {
+		aircraftBySource.WithLabelValues(receiver, band, string(source)).Set(float64(n))
+	}
+	aircraftWithPosition.WithLabelValues(receiver, band).Set(float64(withPosition))
+	aircraftMaxRange.WithLabelValues(receiver, band).Set(maxRange)
+
+	key := [2]string{receiver, band}
+	lastMessagesMu.Lock()
+	last, ok := lastMessages[key]
+	lastMessages[key] = [2]float64{float64(messages), now}
+	lastMessagesMu.Unlock()
+
+	// A counter that went backwards means the decoder restarted
+	if ok && now > last[1] && float64(messages) >= last[0] {
+		messageRate.WithLabelValues(receiver, band).Set((float64(messages) - last[0]) / (now - last[1]))
+	}
+}
+
+// ObserveInvalidAircraft counts an aircraft rejected by validation.
+func ObserveInvalidAircraft(receiver, reason string) {
+	invalidAircraft.WithLabelValues(receiver, reason).Inc()
+}
+
+// ObserveSinkQueue records how many batches are queued for a sink.
+func ObserveSinkQueue(sink string, batches int) {
+	sinkQueue.WithLabelValues(sink).Set(float64(batches))
+}
+
+// ObserveSinkFailure counts a failed attempt to write to a sink.
+func ObserveSinkFailure(sink string) {
+	sinkFailures.WithLabelValues(sink).Inc()
+}
+
+// ObserveSinkDropped counts records a sink never received.
+func ObserveSinkDropped(sink string, records int) {
+	sinkDropped.WithLabelValues(sink).Add(float64(records))
+}
@@ -0,0 +1,371 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Aircraft is a single aircraft as reported in aircraft.json. Every source
+// normalizes into this type.
+//
+// Numeric fields are pointers so that a value of zero (level flight, due
+// north) can be told apart from a field the receiver didn't report.
+type Aircraft struct {
+	Hex    string `json:"hex"`
+	Type   string `json:"type"`
+	Flight string `json:"flight,omitempty"`
+	R      string `json:"r"`
+	T      string `json:"t"`
+	Desc   string `json:"desc"`
+	// AltBaro is the barometric altitude in feet. aircraft.json reports
+	// "ground" instead of a number for aircraft on the ground; that is
+	// decoded into Ground with AltBaro left nil.
+	AltBaro        *int          `json:"alt_baro,omitempty"`
+	Ground         bool          `json:"ground,omitempty"`
+	AltGeom        *int          `json:"alt_geom,omitempty"`
+	Gs             *float64      `json:"gs,omitempty"`
+	Ias            *int          `json:"ias,omitempty"`
+	Tas            *int          `json:"tas,omitempty"`
+	Mach           *float64      `json:"mach,omitempty"`
+	Wd             *int          `json:"wd,omitempty"`
+	Ws             *int          `json:"ws,omitempty"`
+	Oat            *int          `json:"oat,omitempty"`
+	Tat            *int          `json:"tat,omitempty"`
+	Track          *float64      `json:"track,omitempty"`
+	TrackRate      *float64      `json:"track_rate,omitempty"`
+	Roll           *float64      `json:"roll,omitempty"`
+	MagHeading     *float64      `json:"mag_heading,omitempty"`
+	TrueHeading    *float64      `json:"true_heading,omitempty"`
+	BaroRate       *int          `json:"baro_rate,omitempty"`
+	GeomRate       *int          `json:"geom_rate,omitempty"`
+	Squawk         string        `json:"squawk,omitempty"`
+	Category       string        `json:"category,omitempty"`
+	NavQnh         *float64      `json:"nav_qnh,omitempty"`
+	NavAltitudeMcp *int          `json:"nav_altitude_mcp,omitempty"`
+	NavHeading     *float64      `json:"nav_heading,omitempty"`
+	Lat            *float64      `json:"lat,omitempty"`
+	Lon            *float64      `json:"lon,omitempty"`
+	Nic            *int          `json:"nic,omitempty"`
+	Rc             *int          `json:"rc,omitempty"`
+	SeenPos        *float64      `json:"seen_pos,omitempty"`
+	RDst           *float64      `json:"r_dst,omitempty"`
+	RDir           *float64      `json:"r_dir,omitempty"`
+	Version        *int          `json:"version,omitempty"`
+	NicBaro        *int          `json:"nic_baro,omitempty"`
+	NacP           *int          `json:"nac_p,omitempty"`
+	NacV           *int          `json:"nac_v,omitempty"`
+	Sil            *int          `json:"sil,omitempty"`
+	SilType        string        `json:"sil_type"`
+	Gva            *int          `json:"gva,omitempty"`
+	Sda            *int          `json:"sda,omitempty"`
+	Alert          *int          `json:"alert,omitempty"`
+	Spi            *int          `json:"spi,omitempty"`
+	Mlat           FieldSet      `json:"mlat"`
+	Tisb           FieldSet      `json:"tisb"`
+	Messages       int           `json:"messages"`
+	Seen           float64       `json:"seen"`
+	Rssi           *float64      `json:"rssi,omitempty"`
+	NavAltitudeFms *int          `json:"nav_altitude_fms,omitempty"`
+	OwnOp          string        `json:"ownOp,omitempty"`
+	Year           string        `json:"year,omitempty"`
+	Emergency      string        `json:"emergency,omitempty"`
+	NavModes       []string      `json:"nav_modes,omitempty"`
+	DbFlags        int           `json:"dbFlags,omitempty"`
+	LastPosition   *LastPosition `json:"lastPosition,omitempty"`
+	NicA           *int          `json:"nic_a,omitempty"`
+	CalcTrack      *float64      `json:"calc_track,omitempty"`
+	RrLat          *float64      `json:"rr_lat,omitempty"`
+	RrLon          *float64      `json:"rr_lon,omitempty"`
+	GpsOkBefore    *float64      `json:"gpsOkBefore,omitempty"`
+	GpsOkLat       *float64      `json:"gpsOkLat,omitempty"`
+	GpsOkLon       *float64      `json:"gpsOkLon,omitempty"`
+	Dst            *float64      `json:"dst,omitempty"`
+	Dir            *float64      `json:"dir,omitempty"`
+	ReceiverCount  *int          `json:"receiverCount,omitempty"`
+	AcasRA         *AcasRA       `json:"acas_ra,omitempty"`
+
+	// Extra holds fields this model doesn't know about, so they survive a
+	// decode/encode round trip into Loki unchanged
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// LastPosition is the last known position of an aircraft whose current
+// position has timed out.
+type LastPosition struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Nic     int     `json:"nic"`
+	Rc      int     `json:"rc"`
+	SeenPos float64 `json:"seen_pos"`
+}
+
+// AcasRA is the most recent ACAS/TCAS resolution advisory decoded by readsb.
+type AcasRA struct {
+	UTC                string  `json:"utc,omitempty"`
+	UnixTimestamp      float64 `json:"unix_timestamp,omitempty"`
+	DFType             int     `json:"df_type,omitempty"`
+	FullBytes          string  `json:"full_bytes,omitempty"`
+	Bytes              string  `json:"bytes,omitempty"`
+	ARA                string  `json:"ARA,omitempty"`
+	RAT                string  `json:"RAT,omitempty"`
+	MTE                string  `json:"MTE,omitempty"`
+	RAC                string  `json:"RAC,omitempty"`
+	AdvisoryComplement string  `json:"advisory_complement,omitempty"`
+	Advisory           string  `json:"advisory,omitempty"`
+	TTI                string  `json:"TTI,omitempty"`
+	ThreatIDHex        string  `json:"threat_id_hex,omitempty"`
+}
+
+// Aircraft types reported in the "type" field of aircraft.json, describing
+// how the address and data were received.
+const (
+	TypeADSBICAO      = "adsb_icao"
+	TypeADSBICAONT    = "adsb_icao_nt"
+	TypeADSRICAO      = "adsr_icao"
+	TypeTISBICAO      = "tisb_icao"
+	TypeADSC          = "adsc"
+	TypeMLAT          = "mlat"
+	TypeOther         = "other"
+	TypeModeS         = "mode_s"
+	TypeADSBOther     = "adsb_other"
+	TypeADSROther     = "adsr_other"
+	TypeTISBOther     = "tisb_other"
+	TypeTISBTrackfile = "tisb_trackfile"
+	TypeModeA         = "mode_a"
+	TypeUnknown       = "unknown"
+)
+
+var aircraftFields = jsonFieldIndex(reflect.TypeOf(Aircraft{}))
+
+// UnmarshalJSON decodes aircraft.json, where alt_baro is either a number of
+// feet or the string "ground". The object is split into its members once;
+// those the model knows are decoded into their fields and the rest are kept
+// in Extra.
+func (a *Aircraft) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(a).Elem()
+	var altBaro json.RawMessage
+	a.Extra = nil
+	for name, raw := range members {
+		if name == "alt_baro" {
+			altBaro = raw
+			continue
+		}
+		i, ok := aircraftFields[name]
+		if !ok {
+			if a.Extra == nil {
+				a.Extra = make(map[string]json.RawMessage)
+			}
+			a.Extra[name] = raw
+			continue
+		}
+		if err := json.Unmarshal(raw, v.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("cannot unmarshal %s: %w", name, err)
+		}
+	}
+
+	a.AltBaro = nil
+	if len(altBaro) == 0 || string(altBaro) == "null" {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(altBaro, &s); err == nil {
+		if s == "ground" {
+			a.Ground = true
+			return nil
+		}
+		// Some sources quote numbers
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal alt_baro %q", s)
+		}
+		a.AltBaro = Ptr(int(math.Round(n)))
+		return nil
+	}
+
+	var n float64
+	if err := json.Unmarshal(altBaro, &n); err != nil {
+		return fmt.Errorf("cannot unmarshal alt_baro %s", string(altBaro))
+	}
+	if !a.Ground {
+		a.AltBaro = Ptr(int(math.Round(n)))
+	}
+	return nil
+}
+
+// MarshalJSON encodes the aircraft along with any fields preserved in Extra.
+func (a Aircraft) MarshalJSON() ([]byte, error) {
+	type plain Aircraft
+	data, err := json.Marshal(plain(a))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(data, a.Extra)
+}
+
+// Bits of the dbFlags field, from the readsb/tar1090 aircraft database.
+const (
+	DbFlagMilitary    = 1 << 0
+	DbFlagInteresting = 1 << 1
+	DbFlagPIA         = 1 << 2
+	DbFlagLADD        = 1 << 3
+)
+
+// IsMilitary reports whether the aircraft database lists the aircraft as
+// military.
+func (a Aircraft) IsMilitary() bool {
+	return a.DbFlags&DbFlagMilitary != 0
+}
+
+// IsInteresting reports whether the aircraft database flags the aircraft as
+// interesting, such as government, royal or historic aircraft.
+func (a Aircraft) IsInteresting() bool {
+	return a.DbFlags&DbFlagInteresting != 0
+}
+
+// IsPIA reports whether the aircraft uses an FAA Privacy ICAO Address.
+func (a Aircraft) IsPIA() bool {
+	return a.DbFlags&DbFlagPIA != 0
+}
+
+// IsLADD reports whether the aircraft is on the FAA Limiting Aircraft Data
+// Displayed list.
+func (a Aircraft) IsLADD() bool {
+	return a.DbFlags&DbFlagLADD != 0
+}
+
+// DbFlagNames returns the names of the dbFlags bits set on the aircraft.
+func (a Aircraft) DbFlagNames() []string {
+	var names []string
+	if a.IsMilitary() {
+		names = append(names, "military")
+	}
+	if a.IsInteresting() {
+		names = append(names, "interesting")
+	}
+	if a.IsPIA() {
+		names = append(names, "pia")
+	}
+	if a.IsLADD() {
+		names = append(names, "ladd")
+	}
+	return names
+}
+
+// Source identifies where an aircraft's data came from.
+type Source string
+
+const (
+	SourceADSB  Source = "adsb"
+	SourceADSR  Source = "adsr"
+	SourceADSC  Source = "adsc"
+	SourceMLAT  Source = "mlat"
+	SourceTISB  Source = "tisb"
+	SourceModeS Source = "mode_s"
+	SourceOther Source = "other"
+)
+
+// NewAircraft returns an aircraft with its address normalized for the given
+// aircraft type.
+func NewAircraft(hex, aircraftType string) Aircraft {
+	return Aircraft{
+		Hex:  NormalizeHex(hex, aircraftType),
+		Type: aircraftType,
+	}
+}
+
+// NormalizeHex lowercases an address and applies the "~" prefix readsb uses
+// for non-ICAO addresses, so they never collide with an ICAO address.
+func NormalizeHex(hex, aircraftType string) string {
+	hex = strings.ToLower(strings.TrimSpace(hex))
+	if strings.HasPrefix(hex, "~") {
+		return hex
+	}
+
+	switch aircraftType {
+	case TypeADSBOther, TypeADSROther, TypeTISBOther, TypeTISBTrackfile:
+		return "~" + hex
+	default:
+		return hex
+	}
+}
+
+// LastKnownPosition returns the current position, or the last position
+// readsb remembered after the current one timed out, with its age in seconds.
+func (a Aircraft) LastKnownPosition() (lat, lon, seenPos float64, ok bool) {
+	if a.HasPosition() {
+		return *a.Lat, *a.Lon, Deref(a.SeenPos), true
+	}
+	if a.LastPosition != nil {
+		return a.LastPosition.Lat, a.LastPosition.Lon, a.LastPosition.SeenPos, true
+	}
+	return 0, 0, 0, false
+}
+
+// HasPosition reports whether the aircraft has a decoded position.
+func (a Aircraft) HasPosition() bool {
+	return a.Lat != nil && a.Lon != nil
+}
+
+// OnGround reports whether the aircraft reported itself on the ground.
+func (a Aircraft) OnGround() bool {
+	return a.Ground
+}
+
+// IsMLAT reports whether the aircraft's position was derived by
+// multilateration.
+func (a Aircraft) IsMLAT() bool {
+	return a.Type == TypeMLAT || a.Mlat.Has(FieldLat)
+}
+
+// IsTISB reports whether the aircraft's position was rebroadcast by TIS-B.
+func (a Aircraft) IsTISB() bool {
+	return strings.HasPrefix(a.Type, "tisb_") || a.Tisb.Has(FieldLat)
+}
+
+// Source returns the source of the aircraft's data, based on its type and
+// on which fields were derived by MLAT or TIS-B.
+func (a Aircraft) Source() Source {
+	switch {
+	case a.IsMLAT():
+		return SourceMLAT
+	case a.IsTISB():
+		return SourceTISB
+	}
+
+	switch a.Type {
+	case "", TypeADSBICAO, TypeADSBICAONT, TypeADSBOther:
+		return SourceADSB
+	case TypeADSRICAO, TypeADSROther:
+		return SourceADSR
+	case TypeADSC:
+		return SourceADSC
+	case TypeModeS:
+		return SourceModeS
+	default:
+		return SourceOther
+	}
+}
+
+// Ptr returns a pointer to v, for filling in optional fields.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Deref returns the value p points to, or the zero value when p is nil.
+func Deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
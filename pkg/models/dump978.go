@@ -0,0 +1,129 @@
+package models
+
+import "strings"
+
+// Dump978 is the aircraft.json document served by dump978-fa (skyaware978)
+// for the 978 MHz UAT band.
+type Dump978 struct {
+	Now      float64           `json:"now"`
+	Messages int               `json:"messages"`
+	Aircraft []Dump978Aircraft `json:"aircraft"`
+}
+
+type Dump978Aircraft struct {
+	Hex              string   `json:"hex"`
+	AddressQualifier string   `json:"address_qualifier,omitempty"`
+	AddrType         string   `json:"addr_type,omitempty"`
+	AirGroundState   string   `json:"airground_state,omitempty"`
+	Flight           string   `json:"flight,omitempty"`
+	FlightPlanID     string   `json:"flightplan_id,omitempty"`
+	Squawk           string   `json:"squawk,omitempty"`
+	Category         string   `json:"category,omitempty"`
+	Emergency        string   `json:"emergency,omitempty"`
+	AltBaro          *int     `json:"alt_baro,omitempty"`
+	AltGeom          *int     `json:"alt_geom,omitempty"`
+	BaroRate         *int     `json:"baro_rate,omitempty"`
+	GeomRate         *int     `json:"geom_rate,omitempty"`
+	Gs               *float64 `json:"gs,omitempty"`
+	Track            *float64 `json:"track,omitempty"`
+	MagHeading       *float64 `json:"mag_heading,omitempty"`
+	TrueHeading      *float64 `json:"true_heading,omitempty"`
+	NavQnh           *float64 `json:"nav_qnh,omitempty"`
+	NavAltitudeMcp   *int     `json:"nav_altitude_mcp,omitempty"`
+	NavAltitudeFms   *int     `json:"nav_altitude_fms,omitempty"`
+	NavHeading       *float64 `json:"nav_heading,omitempty"`
+	NavModes         []string `json:"nav_modes,omitempty"`
+	Lat              *float64 `json:"lat,omitempty"`
+	Lon              *float64 `json:"lon,omitempty"`
+	Nic              *int     `json:"nic,omitempty"`
+	NicBaro          *int     `json:"nic_baro,omitempty"`
+	NacP             *int     `json:"nac_p,omitempty"`
+	NacV             *int     `json:"nac_v,omitempty"`
+	Sil              *int     `json:"sil,omitempty"`
+	SilType          string   `json:"sil_type,omitempty"`
+	Gva              *int     `json:"gva,omitempty"`
+	Sda              *int     `json:"sda,omitempty"`
+	UATVersion       *int     `json:"uat_version,omitempty"`
+	Messages         int      `json:"messages"`
+	Seen             float64  `json:"seen"`
+	SeenPos          *float64 `json:"seen_pos,omitempty"`
+	Rssi             *float64 `json:"rssi,omitempty"`
+}
+
+// Qualifier returns the UAT address qualifier, accepting both the raw
+// uat2json name and the skyaware978 aircraft.json name.
+func (a Dump978Aircraft) Qualifier() string {
+	if a.AddressQualifier != "" {
+		return a.AddressQualifier
+	}
+	return a.AddrType
+}
+
+// Normalize converts a UAT track into the shared aircraft representation.
+// Non-ICAO addresses get the "~" prefix so they never collide with a 1090ES
+// aircraft of the same 24-bit address.
+func (a Dump978Aircraft) Normalize() Aircraft {
+	aircraft := NewAircraft(strings.TrimPrefix(a.Hex, "~"), uatAircraftType(a.Qualifier()))
+
+	aircraft.Flight = a.Flight
+	if a.AirGroundState == "ground" {
+		aircraft.Ground = true
+	} else {
+		aircraft.AltBaro = a.AltBaro
+	}
+	aircraft.AltGeom = a.AltGeom
+	aircraft.Gs = a.Gs
+	aircraft.Track = a.Track
+	aircraft.MagHeading = a.MagHeading
+	aircraft.TrueHeading = a.TrueHeading
+	aircraft.BaroRate = a.BaroRate
+	aircraft.GeomRate = a.GeomRate
+	aircraft.Squawk = a.Squawk
+	aircraft.Category = a.Category
+	aircraft.NavQnh = a.NavQnh
+	aircraft.NavAltitudeMcp = a.NavAltitudeMcp
+	aircraft.NavAltitudeFms = a.NavAltitudeFms
+	aircraft.NavHeading = a.NavHeading
+	aircraft.NavModes = a.NavModes
+	aircraft.Lat = a.Lat
+	aircraft.Lon = a.Lon
+	aircraft.Nic = a.Nic
+	aircraft.NicBaro = a.NicBaro
+	aircraft.NacP = a.NacP
+	aircraft.NacV = a.NacV
+	aircraft.Sil = a.Sil
+	aircraft.SilType = a.SilType
+	aircraft.Gva = a.Gva
+	aircraft.Sda = a.Sda
+	aircraft.Version = a.UATVersion
+	aircraft.Emergency = a.Emergency
+	aircraft.Messages = a.Messages
+	aircraft.Seen = a.Seen
+	aircraft.SeenPos = a.SeenPos
+	aircraft.Rssi = a.Rssi
+
+	return aircraft
+}
+
+// uatAircraftType maps a UAT address qualifier onto the readsb/dump1090 "type"
+// vocabulary.
+func uatAircraftType(qualifier string) string {
+	switch qualifier {
+	case "", "adsb_icao":
+		return TypeADSBICAO
+	case "adsb_other", "vehicle", "fixed_beacon", "reserved":
+		return TypeADSBOther
+	case "tisb_icao":
+		return TypeTISBICAO
+	case "tisb_other":
+		return TypeTISBOther
+	case "tisb_trackfile":
+		return TypeTISBTrackfile
+	case "adsr_icao":
+		return TypeADSRICAO
+	case "adsr_other":
+		return TypeADSROther
+	default:
+		return TypeADSBOther
+	}
+}
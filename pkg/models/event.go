@@ -0,0 +1,31 @@
+package models
+
+// Event is something notable detected about an aircraft, or about a region
+// of airspace, that is pushed to Loki alongside the aircraft lines.
+type Event struct {
+	Type     string   `json:"event"`
+	Time     float64  `json:"now"`
+	Hex      string   `json:"hex,omitempty"`
+	Flight   string   `json:"flight,omitempty"`
+	Receiver string   `json:"receiver,omitempty"`
+	Lat      *float64 `json:"lat,omitempty"`
+	Lon      *float64 `json:"lon,omitempty"`
+	// Details carries the evidence for the event, such as the measured and
+	// reported values that disagreed
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// NewEvent returns an event of the given type about an aircraft, positioned
+// at its last known position if it has one.
+func NewEvent(eventType string, now float64, aircraft Aircraft) Event {
+	event := Event{
+		Type:   eventType,
+		Time:   now,
+		Hex:    aircraft.Hex,
+		Flight: aircraft.Flight,
+	}
+	if lat, lon, _, ok := aircraft.LastKnownPosition(); ok {
+		event.Lat, event.Lon = Ptr(lat), Ptr(lon)
+	}
+	return event
+}
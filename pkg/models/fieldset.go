@@ -0,0 +1,178 @@
+package models
+
+import (
+	"encoding/json"
+	"math/bits"
+)
+
+// Field is a tracked aircraft field, named as in the "mlat" and "tisb" lists
+// of aircraft.json.
+type Field uint8
+
+const (
+	FieldCallsign Field = iota
+	FieldAltitude
+	FieldAltGeom
+	FieldGs
+	FieldIas
+	FieldTas
+	FieldMach
+	FieldTrack
+	FieldTrackRate
+	FieldRoll
+	FieldMagHeading
+	FieldTrueHeading
+	FieldBaroRate
+	FieldGeomRate
+	FieldSquawk
+	FieldEmergency
+	FieldCategory
+	FieldNavQnh
+	FieldNavAltitudeMcp
+	FieldNavAltitudeFms
+	FieldNavHeading
+	FieldNavModes
+	FieldLat
+	FieldLon
+	FieldNic
+	FieldRc
+	FieldNicBaro
+	FieldNacP
+	FieldNacV
+	FieldSil
+	FieldSilType
+	FieldGva
+	FieldSda
+	numFields
+)
+
+var fieldNames = [numFields]string{
+	FieldCallsign:       "callsign",
+	FieldAltitude:       "altitude",
+	FieldAltGeom:        "alt_geom",
+	FieldGs:             "gs",
+	FieldIas:            "ias",
+	FieldTas:            "tas",
+	FieldMach:           "mach",
+	FieldTrack:          "track",
+	FieldTrackRate:      "track_rate",
+	FieldRoll:           "roll",
+	FieldMagHeading:     "mag_heading",
+	FieldTrueHeading:    "true_heading",
+	FieldBaroRate:       "baro_rate",
+	FieldGeomRate:       "geom_rate",
+	FieldSquawk:         "squawk",
+	FieldEmergency:      "emergency",
+	FieldCategory:       "category",
+	FieldNavQnh:         "nav_qnh",
+	FieldNavAltitudeMcp: "nav_altitude_mcp",
+	FieldNavAltitudeFms: "nav_altitude_fms",
+	FieldNavHeading:     "nav_heading",
+	FieldNavModes:       "nav_modes",
+	FieldLat:            "lat",
+	FieldLon:            "lon",
+	FieldNic:            "nic",
+	FieldRc:             "rc",
+	FieldNicBaro:        "nic_baro",
+	FieldNacP:           "nac_p",
+	FieldNacV:           "nac_v",
+	FieldSil:            "sil",
+	FieldSilType:        "sil_type",
+	FieldGva:            "gva",
+	FieldSda:            "sda",
+}
+
+var fieldsByName = func() map[string]Field {
+	m := make(map[string]Field, numFields)
+	for f, name := range fieldNames {
+		m[name] = Field(f)
+	}
+	return m
+}()
+
+func (f Field) String() string {
+	if f < numFields {
+		return fieldNames[f]
+	}
+	return "unknown"
+}
+
+// ParseField returns the field with the given aircraft.json name.
+func ParseField(name string) (Field, bool) {
+	f, ok := fieldsByName[name]
+	return f, ok
+}
+
+// FieldSet is a set of fields, such as those derived from MLAT or TIS-B. It
+// marshals to and from the JSON array of field names used by aircraft.json.
+// Names this version doesn't know about are kept, so that nothing is lost
+// when the set is marshalled again.
+type FieldSet struct {
+	bits    uint64
+	unknown []string
+}
+
+func NewFieldSet(fields ...Field) FieldSet {
+	var s FieldSet
+	for _, f := range fields {
+		s = s.With(f)
+	}
+	return s
+}
+
+func (s FieldSet) Has(f Field) bool {
+	return s.bits&(1<<f) != 0
+}
+
+func (s FieldSet) With(f Field) FieldSet {
+	s.bits |= 1 << f
+	return s
+}
+
+// Len counts the known fields of the set.
+func (s FieldSet) Len() int {
+	return bits.OnesCount64(s.bits)
+}
+
+// Fields returns the known members of the set in declaration order.
+func (s FieldSet) Fields() []Field {
+	fields := make([]Field, 0, s.Len())
+	for f := Field(0); f < numFields; f++ {
+		if s.Has(f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Names returns the names of every member: the known fields in declaration
+// order, then the unknown ones as they were received.
+func (s FieldSet) Names() []string {
+	names := make([]string, 0, s.Len()+len(s.unknown))
+	for _, f := range s.Fields() {
+		names = append(names, f.String())
+	}
+	return append(names, s.unknown...)
+}
+
+func (s FieldSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
+
+// UnmarshalJSON accepts an array of field names.
+func (s *FieldSet) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	*s = FieldSet{}
+	for _, name := range names {
+		if f, ok := ParseField(name); ok {
+			*s = s.With(f)
+		} else {
+			s.unknown = append(s.unknown, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the AircraftsUpdate and AircraftMeta messages in
+// readsb.proto, from the protobuf build of readsb
+// (github.com/Mictronics/readsb-protobuf) that serves aircraft.pb. They are
+// typed in here rather than generated; readsb_test.go checks them against a
+// copy of that file in testdata/readsb.proto, when one is checked in. Only
+// the fields that map onto Aircraft are decoded; everything else is skipped
+// so newer readsb builds stay readable.
+const (
+	updateNow      protowire.Number = 1
+	updateMessages protowire.Number = 2
+	updateAircraft protowire.Number = 3
+
+	metaAddr           protowire.Number = 1
+	metaAddrType       protowire.Number = 2
+	metaFlight         protowire.Number = 3
+	metaSquawk         protowire.Number = 4
+	metaCategory       protowire.Number = 5
+	metaAltBaro        protowire.Number = 6
+	metaAltGeom        protowire.Number = 7
+	metaBaroRate       protowire.Number = 8
+	metaGeomRate       protowire.Number = 9
+	metaIas            protowire.Number = 10
+	metaTas            protowire.Number = 11
+	metaMach           protowire.Number = 12
+	metaGs             protowire.Number = 13
+	metaTrack          protowire.Number = 14
+	metaTrackRate      protowire.Number = 15
+	metaRoll           protowire.Number = 16
+	metaMagHeading     protowire.Number = 17
+	metaTrueHeading    protowire.Number = 18
+	metaNavQnh         protowire.Number = 19
+	metaNavAltitudeMcp protowire.Number = 20
+	metaNavAltitudeFms protowire.Number = 21
+	metaNavHeading     protowire.Number = 22
+	metaLat            protowire.Number = 23
+	metaLon            protowire.Number = 24
+	metaNic            protowire.Number = 25
+	metaRc             protowire.Number = 26
+	metaSeenPos        protowire.Number = 27
+	metaVersion        protowire.Number = 28
+	metaNicBaro        protowire.Number = 29
+	metaNacP           protowire.Number = 30
+	metaNacV           protowire.Number = 31
+	metaSil            protowire.Number = 32
+	metaSilType        protowire.Number = 33
+	metaGva            protowire.Number = 34
+	metaSda            protowire.Number = 35
+	metaMessages       protowire.Number = 36
+	metaSeen           protowire.Number = 37
+	metaRssi           protowire.Number = 38
+	metaAirGround      protowire.Number = 39
+	metaEmergency      protowire.Number = 40
+	metaWd             protowire.Number = 41
+	metaWs             protowire.Number = 42
+	metaOat            protowire.Number = 43
+	metaTat            protowire.Number = 44
+	metaDistance       protowire.Number = 45
+	metaDirection      protowire.Number = 46
+)
+
+// readsb AircraftMeta.AddrType, in enum order.
+var readsbAddrTypes = []string{
+	TypeADSBICAO, TypeADSBICAONT, TypeADSRICAO, TypeTISBICAO,
+	TypeADSBOther, TypeADSROther, TypeTISBTrackfile, TypeTISBOther,
+	TypeModeA, TypeUnknown,
+}
+
+var readsbSilTypes = []string{"", "unknown", "persample", "perhour"}
+
+var readsbEmergencies = []string{
+	"none", "general", "lifeguard", "minfuel", "nordo", "unlawful", "downed", "reserved",
+}
+
+const readsbAirGroundGround = 1
+
+// UnmarshalAircraftsUpdate decodes a readsb protobuf AircraftsUpdate message
+// (aircraft.pb) into the same document as dump1090-fa's aircraft.json.
+func UnmarshalAircraftsUpdate(data []byte) (*Dump1090fa, error) {
+	update := &Dump1090fa{}
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid AircraftsUpdate tag: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		switch {
+		case num == updateNow && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid AircraftsUpdate.now: %w", protowire.ParseError(n))
+			}
+			update.Now = float64(v)
+			data = data[n:]
+		case num == updateMessages && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid AircraftsUpdate.messages: %w", protowire.ParseError(n))
+			}
+			update.Messages = int(v)
+			data = data[n:]
+		case num == updateAircraft && typ == protowire.BytesType:
+			b, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid AircraftsUpdate.aircraft: %w", protowire.ParseError(n))
+			}
+			aircraft, err := unmarshalAircraftMeta(b)
+			if err != nil {
+				return nil, err
+			}
+			update.Aircraft = append(update.Aircraft, aircraft)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid AircraftsUpdate field %d: %w", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+
+	return update, nil
+}
+
+func unmarshalAircraftMeta(data []byte) (Aircraft, error) {
+	var (
+		aircraft  Aircraft
+		addr      uint32
+		addrType  string
+		altBaro   *int
+		airGround uint64
+	)
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return aircraft, fmt.Errorf("invalid AircraftMeta tag: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var (
+			varint uint64
+			f32    float64
+			f64    float64
+			str    string
+		)
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			// Go via the shortest float32 representation so 450.1 doesn't
+			// become 450.1000061035156 in the JSON line
+			f32, _ = strconv.ParseFloat(strconv.FormatFloat(float64(math.Float32frombits(v)), 'f', -1, 32), 64)
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(data)
+			f64 = math.Float64frombits(v)
+		case protowire.BytesType:
+			var b []byte
+			b, n = protowire.ConsumeBytes(data)
+			str = string(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return aircraft, fmt.Errorf("invalid AircraftMeta field %d: %w", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		switch num {
+		case metaAddr:
+			addr = uint32(varint)
+		case metaAddrType:
+			addrType = enumName(readsbAddrTypes, varint)
+		case metaFlight:
+			aircraft.Flight = str
+		case metaSquawk:
+			aircraft.Squawk = fmt.Sprintf("%04x", varint)
+		case metaCategory:
+			aircraft.Category = fmt.Sprintf("%02X", varint)
+		case metaAltBaro:
+			altBaro = Ptr(int(int32(varint)))
+		case metaAltGeom:
+			aircraft.AltGeom = Ptr(int(int32(varint)))
+		case metaBaroRate:
+			aircraft.BaroRate = Ptr(int(int32(varint)))
+		case metaGeomRate:
+			aircraft.GeomRate = Ptr(int(int32(varint)))
+		case metaIas:
+			aircraft.Ias = Ptr(int(varint))
+		case metaTas:
+			aircraft.Tas = Ptr(int(varint))
+		case metaMach:
+			aircraft.Mach = Ptr(f32)
+		case metaGs:
+			aircraft.Gs = Ptr(f32)
+		case metaTrack:
+			aircraft.Track = Ptr(f32)
+		case metaTrackRate:
+			aircraft.TrackRate = Ptr(f32)
+		case metaRoll:
+			aircraft.Roll = Ptr(f32)
+		case metaMagHeading:
+			aircraft.MagHeading = Ptr(f32)
+		case metaTrueHeading:
+			aircraft.TrueHeading = Ptr(f32)
+		case metaNavQnh:
+			aircraft.NavQnh = Ptr(f32)
+		case metaNavAltitudeMcp:
+			aircraft.NavAltitudeMcp = Ptr(int(int32(varint)))
+		case metaNavAltitudeFms:
+			aircraft.NavAltitudeFms = Ptr(int(int32(varint)))
+		case metaNavHeading:
+			aircraft.NavHeading = Ptr(f32)
+		case metaLat:
+			aircraft.Lat = Ptr(f64)
+		case metaLon:
+			aircraft.Lon = Ptr(f64)
+		case metaNic:
+			aircraft.Nic = Ptr(int(varint))
+		case metaRc:
+			aircraft.Rc = Ptr(int(varint))
+		case metaSeenPos:
+			aircraft.SeenPos = Ptr(f32)
+		case metaVersion:
+			aircraft.Version = Ptr(int(int32(varint)))
+		case metaNicBaro:
+			aircraft.NicBaro = Ptr(int(varint))
+		case metaNacP:
+			aircraft.NacP = Ptr(int(varint))
+		case metaNacV:
+			aircraft.NacV = Ptr(int(varint))
+		case metaSil:
+			aircraft.Sil = Ptr(int(varint))
+		case metaSilType:
+			aircraft.SilType = enumName(readsbSilTypes, varint)
+		case metaGva:
+			aircraft.Gva = Ptr(int(varint))
+		case metaSda:
+			aircraft.Sda = Ptr(int(varint))
+		case metaMessages:
+			aircraft.Messages = int(varint)
+		case metaSeen:
+			aircraft.Seen = f32
+		case metaRssi:
+			aircraft.Rssi = Ptr(f32)
+		case metaAirGround:
+			airGround = varint
+		case metaEmergency:
+			aircraft.Emergency = enumName(readsbEmergencies, varint)
+		case metaWd:
+			aircraft.Wd = Ptr(int(int32(varint)))
+		case metaWs:
+			aircraft.Ws = Ptr(int(int32(varint)))
+		case metaOat:
+			aircraft.Oat = Ptr(int(int32(varint)))
+		case metaTat:
+			aircraft.Tat = Ptr(int(int32(varint)))
+		case metaDistance:
+			aircraft.RDst = Ptr(f32)
+		case metaDirection:
+			aircraft.RDir = Ptr(f32)
+		}
+	}
+
+	if addrType == "" {
+		addrType = readsbAddrTypes[0]
+	}
+	identity := NewAircraft(fmt.Sprintf("%06x", addr&0xffffff), addrType)
+	aircraft.Hex, aircraft.Type = identity.Hex, identity.Type
+
+	if airGround == readsbAirGroundGround {
+		aircraft.Ground = true
+	} else {
+		aircraft.AltBaro = altBaro
+	}
+
+	return aircraft, nil
+}
+
+func enumName(names []string, v uint64) string {
+	if v < uint64(len(names)) {
+		return names[v]
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package models
+
+// Dump1090faStats is the stats.json document published by dump1090-fa and
+// readsb. Each period covers a different window of receiver activity.
+type Dump1090faStats struct {
+	Latest    StatsPeriod `json:"latest"`
+	Last1Min  StatsPeriod `json:"last1min"`
+	Last5Min  StatsPeriod `json:"last5min"`
+	Last15Min StatsPeriod `json:"last15min"`
+	Total     StatsPeriod `json:"total"`
+}
+
+type StatsPeriod struct {
+	Start              float64                `json:"start"`
+	End                float64                `json:"end"`
+	Messages           int                    `json:"messages"`
+	AltitudeSuppressed int                    `json:"altitude_suppressed,omitempty"`
+	Local              LocalStats             `json:"local"`
+	Remote             RemoteStats            `json:"remote"`
+	CPR                CPRStats               `json:"cpr"`
+	CPU                CPUStats               `json:"cpu"`
+	Tracks             TracksStats            `json:"tracks"`
+	MessagesByDF       []int                  `json:"messages_by_df,omitempty"`
+	Adaptive           map[string]interface{} `json:"adaptive,omitempty"`
+}
+
+type LocalStats struct {
+	SamplesProcessed int64   `json:"samples_processed"`
+	SamplesDropped   int64   `json:"samples_dropped"`
+	ModeAC           int     `json:"modeac"`
+	ModeS            int     `json:"modes"`
+	Bad              int     `json:"bad"`
+	UnknownICAO      int     `json:"unknown_icao"`
+	Accepted         []int   `json:"accepted"`
+	Signal           float64 `json:"signal"`
+	Noise            float64 `json:"noise"`
+	PeakSignal       float64 `json:"peak_signal"`
+	StrongSignals    int     `json:"strong_signals"`
+}
+
+type RemoteStats struct {
+	ModeAC      int   `json:"modeac"`
+	ModeS       int   `json:"modes"`
+	Bad         int   `json:"bad"`
+	UnknownICAO int   `json:"unknown_icao"`
+	Accepted    []int `json:"accepted"`
+}
+
+type CPRStats struct {
+	Surface               int `json:"surface"`
+	Airborne              int `json:"airborne"`
+	GlobalOK              int `json:"global_ok"`
+	GlobalBad             int `json:"global_bad"`
+	GlobalRange           int `json:"global_range"`
+	GlobalSpeed           int `json:"global_speed"`
+	GlobalSkipped         int `json:"global_skipped"`
+	LocalOK               int `json:"local_ok"`
+	LocalAircraftRelative int `json:"local_aircraft_relative"`
+	LocalReceiverRelative int `json:"local_receiver_relative"`
+	LocalSkipped          int `json:"local_skipped"`
+	LocalRange            int `json:"local_range"`
+	LocalSpeed            int `json:"local_speed"`
+	Filtered              int `json:"filtered"`
+}
+
+type CPUStats struct {
+	Demod      int `json:"demod"`
+	Reader     int `json:"reader"`
+	Background int `json:"background"`
+}
+
+type TracksStats struct {
+	All           int `json:"all"`
+	SingleMessage int `json:"single_message"`
+	Unreliable    int `json:"unreliable,omitempty"`
+}
+
+// AcceptedTotal sums the accepted message counts over all error-correction
+// levels.
+func (s StatsPeriod) AcceptedTotal() (local, remote int) {
+	for _, n := range s.Local.Accepted {
+		local += n
+	}
+	for _, n := range s.Remote.Accepted {
+		remote += n
+	}
+	return local, remote
+}
@@ -0,0 +1,382 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const (
+	// lostAfter is how long an aircraft may be missing from a receiver's
+	// snapshots before it is announced as lost
+	lostAfter = 60 * time.Second
+	// publishTimeout bounds how long Write waits for the broker to accept
+	// a batch
+	publishTimeout = 10 * time.Second
+	// allReceivers stands in for the receiver in topics of records that
+	// don't belong to one
+	allReceivers = "all"
+)
+
+// Lifecycle states published when an aircraft appears at or disappears from
+// a receiver.
+const (
+	LifecycleAppeared = "appeared"
+	LifecycleLost     = "lost"
+)
+
+type Config struct {
+	// Broker is the broker URL, such as tcp://localhost:1883 or
+	// ssl://broker:8883
+	Broker      string
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	QoS         byte
+	// RetainAircraft keeps the last report of every aircraft on its topic,
+	// until it is lost
+	RetainAircraft bool
+
+	// CAFile, CertFile and KeyFile configure TLS with a private CA and
+	// client certificates
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// LoadConfig reads the sink configuration from MQTT_* environment variables.
+// It returns nil when MQTT_BROKER is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("mqtt.LoadConfig")
+
+	config := &Config{
+		Broker:             os.Getenv("MQTT_BROKER"),
+		ClientID:           os.Getenv("MQTT_CLIENT_ID"),
+		Username:           os.Getenv("MQTT_USERNAME"),
+		Password:           os.Getenv("MQTT_PASSWORD"),
+		TopicPrefix:        strings.Trim(os.Getenv("MQTT_TOPIC_PREFIX"), "/"),
+		RetainAircraft:     os.Getenv("MQTT_RETAIN_AIRCRAFT") == "true",
+		CAFile:             os.Getenv("MQTT_TLS_CA"),
+		CertFile:           os.Getenv("MQTT_TLS_CERT"),
+		KeyFile:            os.Getenv("MQTT_TLS_KEY"),
+		InsecureSkipVerify: os.Getenv("MQTT_TLS_INSECURE_SKIP_VERIFY") == "true",
+	}
+	if config.Broker == "" {
+		return nil, nil
+	}
+
+	if config.ClientID == "" {
+		config.ClientID = "adsb2loki"
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "adsb"
+	}
+	if qos := os.Getenv("MQTT_QOS"); qos != "" {
+		n, err := strconv.Atoi(qos)
+		if err != nil || n < 0 || n > 2 {
+			return nil, fmt.Errorf("invalid MQTT_QOS %q: must be 0, 1 or 2", qos)
+		}
+		config.QoS = byte(n)
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("MQTT_TLS_CERT and MQTT_TLS_KEY must be set together")
+	}
+
+	logging.Debug("MQTT sink configured", "broker", config.Broker, "client_id", config.ClientID, "topic_prefix", config.TopicPrefix, "qos", config.QoS, "retain_aircraft", config.RetainAircraft, "username", config.Username)
+	return config, nil
+}
+
+// Sink publishes records to an MQTT broker:
+//
+//	<prefix>/status                        "online" or "offline", retained; "offline" is the Last Will
+//	<prefix>/<receiver>/aircraft/<hex>     every aircraft report
+//	<prefix>/<receiver>/summary            counts of the latest snapshot, retained
+//	<prefix>/<receiver>/stats              the latest receiver stats, retained
+//	<prefix>/<receiver>/events/<type>      every event
+//	<prefix>/<receiver>/lifecycle          aircraft appearing and being lost
+type Sink struct {
+	config Config
+	client paho.Client
+
+	mu sync.Mutex
+	// seen is when each aircraft was last reported, per receiver
+	seen map[string]map[string]time.Time
+}
+
+// lifecycle is the payload announcing an aircraft appearing or being lost.
+type lifecycle struct {
+	State    string  `json:"state"`
+	Hex      string  `json:"hex"`
+	Receiver string  `json:"receiver"`
+	Time     float64 `json:"time"`
+}
+
+// summary is the payload describing a receiver's latest snapshot.
+type summary struct {
+	Time         float64        `json:"time"`
+	Aircraft     int            `json:"aircraft"`
+	WithPosition int            `json:"with_position"`
+	Sources      map[string]int `json:"sources"`
+	MaxRange     float64        `json:"max_range_nm"`
+}
+
+// New connects to the broker in the background; until the connection is up,
+// writes fail and are retried.
+func New(config Config) (*Sink, error) {
+	logging.DebugCall("mqtt.New", "broker", config.Broker, "client_id", config.ClientID)
+
+	s := &Sink{
+		config: config,
+		seen:   make(map[string]map[string]time.Time),
+	}
+
+	statusTopic := config.TopicPrefix + "/status"
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(statusTopic, "offline", config.QoS, true).
+		SetOnConnectHandler(func(c paho.Client) {
+			logging.Info("Connected to MQTT broker", "broker", config.Broker)
+			c.Publish(statusTopic, config.QoS, true, "online")
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logging.Warn("Lost connection to MQTT broker", "error", err, "broker", config.Broker)
+		})
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	s.client = paho.NewClient(opts)
+	s.client.Connect()
+
+	logging.Info("MQTT sink created", "broker", config.Broker, "topic_prefix", config.TopicPrefix)
+	return s, nil
+}
+
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Write publishes the records and waits for the broker to accept them at the
+// configured QoS.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	logging.DebugCall("mqtt.Write", "records", len(records))
+
+	if !s.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to MQTT broker %s", s.config.Broker)
+	}
+
+	var tokens []paho.Token
+	publish := func(topic string, retained bool, payload interface{}) {
+		tokens = append(tokens, s.client.Publish(topic, s.config.QoS, retained, payload))
+	}
+
+	summaries := make(map[string]*summary)
+	for _, r := range records {
+		receiver := topicLevel(r.Receiver)
+		switch r.Kind {
+		case sink.KindAircraft:
+			publish(s.topic(receiver, "aircraft", topicLevel(r.Aircraft.Hex)), s.config.RetainAircraft, r.Line)
+			if summaries[receiver] == nil {
+				summaries[receiver] = &summary{Sources: make(map[string]int)}
+			}
+			summaries[receiver].add(r)
+		case sink.KindEvent:
+			publish(s.topic(receiver, "events", topicLevel(r.Event.Type)), false, r.Line)
+		case sink.KindStats:
+			publish(s.topic(receiver, "stats"), true, r.Line)
+		}
+	}
+
+	for receiver, sum := range summaries {
+		payload, err := json.Marshal(sum)
+		if err != nil {
+			return fmt.Errorf("failed to marshal summary: %w", err)
+		}
+		publish(s.topic(receiver, "summary"), true, payload)
+	}
+
+	now := time.Now()
+	changes := s.lifecycle(records, now)
+	for _, change := range changes {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return fmt.Errorf("failed to marshal lifecycle event: %w", err)
+		}
+		publish(s.topic(change.Receiver, "lifecycle"), false, payload)
+		if change.State == LifecycleLost && s.config.RetainAircraft {
+			// An empty retained message removes the retained report
+			publish(s.topic(change.Receiver, "aircraft", change.Hex), true, []byte{})
+		}
+	}
+
+	var errs []error
+	for _, token := range tokens {
+		if !token.WaitTimeout(publishTimeout) {
+			return fmt.Errorf("timed out publishing to MQTT broker %s", s.config.Broker)
+		}
+		if err := token.Error(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		logging.Error("Failed to publish to MQTT broker", "error", err, "failed", len(errs), "messages", len(tokens))
+		return fmt.Errorf("failed to publish %d of %d messages: %w", len(errs), len(tokens), err)
+	}
+
+	// Only now that the lifecycle changes are out, so a retried batch
+	// announces them again
+	s.remember(records, changes, now)
+
+	logging.Debug("Successfully published to MQTT broker", "messages", len(tokens))
+	return nil
+}
+
+// lifecycle returns the aircraft each receiver reported that it hadn't
+// before, and the ones no longer reported for lostAfter. It doesn't change
+// what has been seen; remember does, once the changes are published.
+func (s *Sink) lifecycle(records []sink.Record, now time.Time) []lifecycle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []lifecycle
+	reported := make(map[string]map[string]bool)
+	for _, r := range records {
+		if r.Kind != sink.KindAircraft {
+			continue
+		}
+		receiver, hex := topicLevel(r.Receiver), topicLevel(r.Aircraft.Hex)
+		if reported[receiver] == nil {
+			reported[receiver] = make(map[string]bool)
+		}
+		if reported[receiver][hex] {
+			continue
+		}
+		reported[receiver][hex] = true
+		if _, ok := s.seen[receiver][hex]; !ok {
+			changes = append(changes, lifecycle{State: LifecycleAppeared, Hex: hex, Receiver: receiver, Time: unix(r.Timestamp)})
+		}
+	}
+
+	for receiver, aircraft := range s.seen {
+		for hex, last := range aircraft {
+			if !reported[receiver][hex] && now.Sub(last) > lostAfter {
+				changes = append(changes, lifecycle{State: LifecycleLost, Hex: hex, Receiver: receiver, Time: unix(now)})
+			}
+		}
+	}
+	return changes
+}
+
+// remember records the aircraft reported in a published batch and forgets
+// the ones announced as lost.
+func (s *Sink) remember(records []sink.Record, changes []lifecycle, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range records {
+		if r.Kind != sink.KindAircraft {
+			continue
+		}
+		receiver, hex := topicLevel(r.Receiver), topicLevel(r.Aircraft.Hex)
+		if s.seen[receiver] == nil {
+			s.seen[receiver] = make(map[string]time.Time)
+		}
+		s.seen[receiver][hex] = now
+	}
+	for _, change := range changes {
+		if change.State == LifecycleLost {
+			delete(s.seen[change.Receiver], change.Hex)
+		}
+	}
+}
+
+func (sum *summary) add(r sink.Record) {
+	a := r.Aircraft
+	sum.Time = max(sum.Time, unix(r.Timestamp))
+	sum.Aircraft++
+	sum.Sources[string(a.Source())]++
+	if a.HasPosition() {
+		sum.WithPosition++
+		sum.MaxRange = max(sum.MaxRange, models.Deref(a.RDst))
+	}
+}
+
+// Flush does nothing, as Write waits for every message to be accepted.
+func (s *Sink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close announces that the service went offline and disconnects. A graceful
+// disconnect doesn't trigger the Last Will, so the status is published here.
+func (s *Sink) Close() error {
+	if s.client.IsConnectionOpen() {
+		s.client.Publish(s.config.TopicPrefix+"/status", s.config.QoS, true, "offline").WaitTimeout(publishTimeout)
+	}
+	s.client.Disconnect(250)
+	return nil
+}
+
+func (s *Sink) topic(levels ...string) string {
+	return s.config.TopicPrefix + "/" + strings.Join(levels, "/")
+}
+
+// topicLevel makes a name safe to use as a single topic level.
+func topicLevel(name string) string {
+	if name == "" {
+		return allReceivers
+	}
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(name)
+}
+
+func unix(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
@@ -0,0 +1,388 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const (
+	defaultBatchSize = 2000
+	// maxBulkAttempts is how often documents rejected with a retryable
+	// status are sent again before the batch is failed
+	maxBulkAttempts = 3
+	initialBackoff  = 500 * time.Millisecond
+)
+
+type Config struct {
+	// URL is the cluster URL, such as https://localhost:9200
+	URL      string
+	Username string
+	Password string
+	// APIKey is sent as an Elasticsearch "ApiKey" authorization instead of
+	// basic auth
+	APIKey string
+	// IndexPrefix starts every index name, followed by the record kind and
+	// the day: adsb-aircraft-2024.05.01
+	IndexPrefix string
+	BatchSize   int
+	// Template installs an index template mapping the prefix's indices
+	// before the first write
+	Template bool
+}
+
+// LoadConfig reads the sink configuration from OPENSEARCH_* environment
+// variables. It returns nil when OPENSEARCH_URL is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("opensearch.LoadConfig")
+
+	config := &Config{
+		URL:         strings.TrimSuffix(os.Getenv("OPENSEARCH_URL"), "/"),
+		Username:    os.Getenv("OPENSEARCH_USERNAME"),
+		Password:    os.Getenv("OPENSEARCH_PASSWORD"),
+		APIKey:      os.Getenv("OPENSEARCH_API_KEY"),
+		IndexPrefix: os.Getenv("OPENSEARCH_INDEX_PREFIX"),
+		BatchSize:   defaultBatchSize,
+		Template:    os.Getenv("OPENSEARCH_TEMPLATE") == "true",
+	}
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	if config.IndexPrefix == "" {
+		config.IndexPrefix = "adsb"
+	}
+	if size := os.Getenv("OPENSEARCH_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid OPENSEARCH_BATCH_SIZE %q", size)
+		}
+		config.BatchSize = n
+	}
+
+	logging.Debug("OpenSearch sink configured", "url", config.URL, "index_prefix", config.IndexPrefix, "batch_size", config.BatchSize, "template", config.Template, "username", config.Username, "api_key_set", config.APIKey != "")
+	return config, nil
+}
+
+// Sink indexes records through the _bulk API of OpenSearch or Elasticsearch,
+// into one index per record kind and day. Documents are the JSON line with
+// "@timestamp", the Loki labels under "labels" and, when the line has a
+// position, a "location" geo_point.
+//
+// Every document has an ID derived from what it describes, so a batch that
+// is retried overwrites the documents already indexed instead of duplicating
+// them.
+type Sink struct {
+	config Config
+	client *http.Client
+	tracer trace.Tracer
+	// templated is set once the index template has been installed
+	templated bool
+}
+
+// document is one bulk index action.
+type document struct {
+	index string
+	id    string
+	body  []byte
+}
+
+// bulkResponse is the part of the _bulk response needed to find rejected
+// documents. Items are in request order.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+func New(config Config) *Sink {
+	logging.DebugCall("opensearch.New", "url", config.URL, "index_prefix", config.IndexPrefix)
+
+	logging.Info("OpenSearch sink created", "url", config.URL, "index_prefix", config.IndexPrefix)
+	return &Sink{
+		config: config,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   30 * time.Second,
+		},
+		tracer: otel.Tracer("opensearch-client"),
+	}
+}
+
+// Write indexes the records in bulk requests of at most BatchSize documents.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	ctx, span := s.tracer.Start(ctx, "opensearch.write",
+		trace.WithAttributes(attribute.Int("records_count", len(records))),
+	)
+	defer span.End()
+
+	logging.DebugCall("opensearch.Write", "records", len(records))
+
+	if s.config.Template && !s.templated {
+		if err := s.putTemplate(ctx); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		s.templated = true
+	}
+
+	docs := make([]document, 0, len(records))
+	for _, r := range records {
+		doc, err := s.document(r)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to build document", "error", err, "kind", r.Kind)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	for start := 0; start < len(docs); start += s.config.BatchSize {
+		end := min(start+s.config.BatchSize, len(docs))
+		if err := s.bulk(ctx, docs[start:end]); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	logging.Debug("Successfully indexed documents", "documents", len(docs))
+	return nil
+}
+
+func (s *Sink) document(r sink.Record) (document, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(r.Line), &fields); err != nil {
+		return document{}, fmt.Errorf("failed to decode %s record: %w", r.Kind, err)
+	}
+
+	timestamp, _ := json.Marshal(r.Timestamp.UTC().Format(time.RFC3339Nano))
+	labels, err := json.Marshal(r.Labels)
+	if err != nil {
+		return document{}, fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	fields["@timestamp"] = timestamp
+	fields["labels"] = labels
+
+	var id string
+	switch r.Kind {
+	case sink.KindAircraft:
+		id = fmt.Sprintf("%s-%s-%d", r.Receiver, r.Aircraft.Hex, r.Timestamp.UnixMilli())
+		if r.Aircraft.HasPosition() {
+			fields["location"], _ = json.Marshal(map[string]float64{"lat": *r.Aircraft.Lat, "lon": *r.Aircraft.Lon})
+		}
+	case sink.KindEvent:
+		id = fmt.Sprintf("%s-%s-%s-%d", r.Receiver, r.Event.Type, r.Event.Hex, r.Timestamp.UnixMilli())
+		if r.Event.Lat != nil && r.Event.Lon != nil {
+			fields["location"], _ = json.Marshal(map[string]float64{"lat": *r.Event.Lat, "lon": *r.Event.Lon})
+		}
+	default:
+		id = fmt.Sprintf("%s-%s-%d", r.Receiver, r.Kind, r.Timestamp.UnixMilli())
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return document{}, fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	return document{
+		index: fmt.Sprintf("%s-%s-%s", s.config.IndexPrefix, r.Kind, r.Timestamp.UTC().Format("2006.01.02")),
+		id:    id,
+		body:  body,
+	}, nil
+}
+
+// bulk sends documents, sending those rejected with a retryable status
+// (429 or 5xx) again with backoff. Documents rejected for good, such as
+// those that don't match the mapping, are logged and dropped.
+func (s *Sink) bulk(ctx context.Context, docs []document) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.send(ctx, docs)
+		if err != nil {
+			return err
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		if attempt >= maxBulkAttempts {
+			return fmt.Errorf("%d documents still rejected after %d attempts", len(retry), attempt)
+		}
+
+		logging.Warn("Documents rejected by bulk request, retrying", "documents", len(retry), "attempt", attempt, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		docs = retry
+	}
+}
+
+// send makes one bulk request and returns the documents to retry.
+func (s *Sink) send(ctx context.Context, docs []document) ([]document, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": doc.index, "_id": doc.id},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal bulk action: %w", err)
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+
+	resp, err := s.request(ctx, "POST", "/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+
+	var retry []document
+	rejected := 0
+	for i, item := range result.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, status := range item {
+			if status.Error == nil {
+				continue
+			}
+			if status.Status == http.StatusTooManyRequests || status.Status >= 500 {
+				retry = append(retry, docs[i])
+				continue
+			}
+			rejected++
+			logging.Error("Document rejected by bulk request", "index", docs[i].index, "id", docs[i].id, "status", status.Status, "type", status.Error.Type, "reason", status.Error.Reason)
+		}
+	}
+	if rejected > 0 {
+		logging.Error("Dropping rejected documents", "documents", rejected)
+	}
+	return retry, nil
+}
+
+// putTemplate installs an index template that maps "location" as a
+// geo_point, along with the fields most often filtered on.
+func (s *Sink) putTemplate(ctx context.Context) error {
+	logging.DebugCall("opensearch.putTemplate", "index_prefix", s.config.IndexPrefix)
+
+	template := map[string]interface{}{
+		"index_patterns": []string{s.config.IndexPrefix + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"@timestamp": map[string]string{"type": "date"},
+					"location":   map[string]string{"type": "geo_point"},
+					"lat":        map[string]string{"type": "double"},
+					"lon":        map[string]string{"type": "double"},
+					"hex":        map[string]string{"type": "keyword"},
+					"flight":     map[string]string{"type": "keyword"},
+					"squawk":     map[string]string{"type": "keyword"},
+					"event":      map[string]string{"type": "keyword"},
+					"receiver":   map[string]string{"type": "keyword"},
+					"labels":     map[string]interface{}{"type": "object", "dynamic": true},
+				},
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"labels": map[string]interface{}{
+							"path_match": "labels.*",
+							"mapping":    map[string]string{"type": "keyword"},
+						},
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index template: %w", err)
+	}
+
+	resp, err := s.request(ctx, "PUT", "/_index_template/"+s.config.IndexPrefix, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to install index template: %w", err)
+	}
+	resp.Body.Close()
+
+	logging.Info("OpenSearch index template installed", "name", s.config.IndexPrefix, "pattern", s.config.IndexPrefix+"-*")
+	return nil
+}
+
+// request sends a request to the cluster and fails on any error status.
+func (s *Sink) request(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	url := s.config.URL + path
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		logging.Error("Failed to create HTTP request", "error", err, "url", url)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "adsb2loki/1.0.0")
+	switch {
+	case s.config.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.config.APIKey)
+	case s.config.Username != "":
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		logging.Error("HTTP request failed", "error", err, "url", url, "duration_ms", duration.Milliseconds())
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	logging.DebugHTTP(method, url, resp.StatusCode, duration)
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		logging.Error("OpenSearch request failed", "status", resp.Status, "url", url, "message", string(message))
+		return nil, fmt.Errorf("request failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// Flush does nothing, as Write indexes straight away.
+func (s *Sink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections to the cluster.
+func (s *Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,210 @@
+package otlplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+	"github.com/burnettdev/adsb2loki/pkg/tracing"
+)
+
+// ReceiverAttribute is the resource attribute naming the receiver a record
+// came from.
+const ReceiverAttribute = "adsb.receiver"
+
+// Sink sends records as OpenTelemetry log records to an OTLP HTTP endpoint.
+// Every receiver is a resource of its own, so a collector can route and
+// enrich its records like those of any other service; records that don't
+// belong to a receiver share a resource without one.
+type Sink struct {
+	options []otlploghttp.Option
+
+	mu        sync.Mutex
+	providers map[string]*sdklog.LoggerProvider
+	loggers   map[string]log.Logger
+}
+
+// New returns a sink exporting to the OTLP logs endpoint configured by the
+// standard OTEL_EXPORTER_OTLP_* variables.
+func New() *Sink {
+	endpoint := tracing.Endpoint(tracing.SignalLogs)
+
+	logging.DebugCall("otlplog.New", "endpoint", endpoint, "insecure", tracing.Insecure(tracing.SignalLogs))
+
+	options := tracing.ExporterOptions(tracing.SignalLogs,
+		otlploghttp.WithEndpoint, otlploghttp.WithURLPath, otlploghttp.WithInsecure, otlploghttp.WithHeaders)
+
+	logging.Info("OTLP logs sink configured", "endpoint", endpoint)
+	return &Sink{
+		options:   options,
+		providers: make(map[string]*sdklog.LoggerProvider),
+		loggers:   make(map[string]log.Logger),
+	}
+}
+
+// Write emits a log record for every record. The line becomes the body, and
+// its fields and the record's labels become attributes. Records are exported
+// in batches in the background; Flush waits for them.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	logging.DebugCall("otlplog.Write", "records", len(records))
+
+	observed := time.Now()
+	for _, r := range records {
+		logger, err := s.logger(r.Receiver)
+		if err != nil {
+			return err
+		}
+
+		var record log.Record
+		record.SetTimestamp(r.Timestamp)
+		record.SetObservedTimestamp(observed)
+		record.SetSeverity(log.SeverityInfo)
+		record.SetSeverityText("INFO")
+		record.SetBody(log.StringValue(r.Line))
+
+		fields, err := attributes(r.Line)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s record: %w", r.Kind, err)
+		}
+		record.AddAttributes(fields...)
+		record.AddAttributes(log.String("adsb.kind", string(r.Kind)))
+		for _, k := range sortedKeys(r.Labels) {
+			record.AddAttributes(log.String(k, r.Labels[k]))
+		}
+
+		logger.Emit(ctx, record)
+	}
+	return nil
+}
+
+// Flush exports every record emitted so far.
+func (s *Sink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, p := range s.providers {
+		errs = append(errs, p.ForceFlush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Close exports every record emitted so far and stops the exporters.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var errs []error
+	for _, p := range s.providers {
+		errs = append(errs, p.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// logger returns the logger for a receiver, creating its provider on first
+// use.
+func (s *Sink) logger(receiver string) (log.Logger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if logger, ok := s.loggers[receiver]; ok {
+		return logger, nil
+	}
+
+	var attrs []attribute.KeyValue
+	if receiver != "" {
+		attrs = append(attrs, attribute.String(ReceiverAttribute, receiver))
+	}
+	res, err := tracing.Resource(attrs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP resource: %w", err)
+	}
+
+	exporter, err := otlploghttp.New(context.Background(), s.options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP log exporter: %w", err)
+	}
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+		sdklog.WithResource(res),
+	)
+	s.providers[receiver] = provider
+	s.loggers[receiver] = provider.Logger("adsb2loki")
+
+	logging.Debug("OTLP logger created", "receiver", receiver)
+	return s.loggers[receiver], nil
+}
+
+// attributes converts the fields of a JSON line into log attributes, keeping
+// nested objects and arrays structured.
+func attributes(line string) ([]log.KeyValue, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(line)))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return keyValues(fields), nil
+}
+
+func keyValues(fields map[string]interface{}) []log.KeyValue {
+	kvs := make([]log.KeyValue, 0, len(fields))
+	for _, k := range sortedKeys(fields) {
+		if fields[k] == nil {
+			continue
+		}
+		kvs = append(kvs, log.KeyValue{Key: k, Value: value(fields[k])})
+	}
+	return kvs
+}
+
+func value(v interface{}) log.Value {
+	switch v := v.(type) {
+	case string:
+		return log.StringValue(v)
+	case bool:
+		return log.BoolValue(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return log.Int64Value(n)
+		}
+		f, _ := v.Float64()
+		return log.Float64Value(f)
+	case []interface{}:
+		values := make([]log.Value, 0, len(v))
+		for _, item := range v {
+			values = append(values, value(item))
+		}
+		return log.SliceValue(values...)
+	case map[string]interface{}:
+		return log.MapValue(keyValues(v)...)
+	default:
+		return log.Value{}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,387 @@
+package parquet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+const (
+	defaultRowGroupSize  = 100000
+	defaultFlushInterval = time.Minute
+	// partialSuffix marks a file still being written. Parquet files are only
+	// readable once their footer is written on close.
+	partialSuffix = ".partial"
+	// incompleteSuffix marks a file that could not be completed. It is kept,
+	// out of the way of queries, in case its row groups can be recovered.
+	incompleteSuffix = ".incomplete"
+)
+
+type Config struct {
+	Dir string
+	// Compression is zstd, snappy, gzip or none
+	Compression string
+	// RowGroupSize is the most rows in one row group
+	RowGroupSize int64
+	// FlushInterval is the longest rows are buffered in memory before they
+	// are written out as a row group
+	FlushInterval time.Duration
+}
+
+// LoadConfig reads the sink configuration from PARQUET_* environment
+// variables. It returns nil when PARQUET_DIR is not set.
+func LoadConfig() (*Config, error) {
+	logging.DebugCall("parquet.LoadConfig")
+
+	config := &Config{
+		Dir:           os.Getenv("PARQUET_DIR"),
+		Compression:   os.Getenv("PARQUET_COMPRESSION"),
+		RowGroupSize:  defaultRowGroupSize,
+		FlushInterval: defaultFlushInterval,
+	}
+	if config.Dir == "" {
+		return nil, nil
+	}
+
+	if config.Compression == "" {
+		config.Compression = "zstd"
+	}
+	if _, err := config.codec(); err != nil {
+		return nil, err
+	}
+	if size := os.Getenv("PARQUET_ROW_GROUP_SIZE"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid PARQUET_ROW_GROUP_SIZE %q", size)
+		}
+		config.RowGroupSize = n
+	}
+	if interval := os.Getenv("PARQUET_FLUSH_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid PARQUET_FLUSH_INTERVAL %q", interval)
+		}
+		config.FlushInterval = d
+	}
+
+	logging.Debug("Parquet sink configured", "dir", config.Dir, "compression", config.Compression, "row_group_size", config.RowGroupSize, "flush_interval", config.FlushInterval)
+	return config, nil
+}
+
+func (c Config) codec() (compress.Codec, error) {
+	switch c.Compression {
+	case "zstd":
+		return &parquetgo.Zstd, nil
+	case "snappy":
+		return &parquetgo.Snappy, nil
+	case "gzip":
+		return &parquetgo.Gzip, nil
+	case "none":
+		return &parquetgo.Uncompressed, nil
+	default:
+		return nil, fmt.Errorf("invalid PARQUET_COMPRESSION %q: must be zstd, snappy, gzip or none", c.Compression)
+	}
+}
+
+// Sink writes aircraft records to hourly Parquet files, partitioned Hive
+// style by date and receiver:
+//
+//	<dir>/date=2024-05-01/receiver=home/aircraft-2024050113.parquet
+//
+// A file is written as <name>.partial and renamed once it is complete, when
+// its hour has passed or the sink is closed, so readers globbing *.parquet
+// only ever see whole files. Buffered rows are written out as a row group
+// at least every FlushInterval, which bounds the memory each file holds.
+// Other record kinds are ignored.
+type Sink struct {
+	config Config
+	codec  compress.Codec
+	tracer trace.Tracer
+	// files are the open files, by partition and hour
+	files map[string]*file
+}
+
+type file struct {
+	path   string
+	hour   time.Time
+	f      *os.File
+	writer *parquetgo.GenericWriter[Row]
+	rows   int64
+	// flushed is when buffered rows were last written out
+	flushed time.Time
+	// done is set once the file is completed or set aside. writer is nil
+	// once its footer has been written.
+	done bool
+}
+
+func New(config Config) (*Sink, error) {
+	logging.DebugCall("parquet.New", "dir", config.Dir, "compression", config.Compression)
+
+	codec, err := config.codec()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create Parquet directory: %w", err)
+	}
+	setAsidePartial(config.Dir)
+
+	logging.Info("Parquet sink created", "dir", config.Dir, "compression", config.Compression)
+	return &Sink{
+		config: config,
+		codec:  codec,
+		tracer: otel.Tracer("parquet"),
+		files:  make(map[string]*file),
+	}, nil
+}
+
+// Write appends the aircraft records to the files of their hours, then
+// completes the files of hours that have passed.
+func (s *Sink) Write(ctx context.Context, records []sink.Record) error {
+	_, span := s.tracer.Start(ctx, "parquet.write",
+		trace.WithAttributes(attribute.Int("records_count", len(records))),
+	)
+	defer span.End()
+
+	logging.DebugCall("parquet.Write", "records", len(records))
+
+	type partition struct {
+		receiver string
+		hour     time.Time
+	}
+	rows := make(map[partition][]Row)
+	for _, r := range records {
+		if r.Kind != sink.KindAircraft {
+			continue
+		}
+		row, err := newRow(r)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to convert record to Parquet row", "error", err, "aircraft_hex", r.Aircraft.Hex)
+			continue
+		}
+		p := partition{r.Receiver, r.Timestamp.UTC().Truncate(time.Hour)}
+		rows[p] = append(rows[p], row)
+	}
+
+	// Every file is opened before any is written to, so failing to open one
+	// leaves nothing behind for the retry to write again. Once rows have been
+	// appended an error would have the whole batch retried and duplicate them,
+	// so later failures are logged and only the rows that failed are lost.
+	files := make(map[partition]*file, len(rows))
+	for p := range rows {
+		f, err := s.file(p.receiver, p.hour)
+		if err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to open Parquet file", "error", err, "receiver", p.receiver)
+			return err
+		}
+		files[p] = f
+	}
+
+	now := time.Now()
+	written := 0
+	for p, batch := range rows {
+		f := files[p]
+		if _, err := f.writer.Write(batch); err != nil {
+			span.RecordError(err)
+			if written == 0 {
+				logging.Error("Failed to write Parquet rows", "error", err, "file", f.path)
+				return fmt.Errorf("failed to write Parquet rows: %w", err)
+			}
+			logging.Error("Failed to write Parquet rows, dropping them", "error", err, "file", f.path, "rows", len(batch))
+			continue
+		}
+		f.rows += int64(len(batch))
+		written += len(batch)
+	}
+
+	for _, f := range s.files {
+		if f.writer == nil || now.Sub(f.flushed) < s.config.FlushInterval {
+			continue
+		}
+		if err := f.writer.Flush(); err != nil {
+			span.RecordError(err)
+			logging.Error("Failed to flush Parquet row group", "error", err, "file", f.path)
+			continue
+		}
+		f.flushed = now
+	}
+
+	if err := s.roll(now.UTC().Truncate(time.Hour)); err != nil {
+		span.RecordError(err)
+	}
+
+	logging.Debug("Wrote Parquet rows", "rows", written, "open_files", len(s.files))
+	return nil
+}
+
+// file returns the open file for a receiver and hour, creating it if needed.
+func (s *Sink) file(receiver string, hour time.Time) (*file, error) {
+	key := receiver + "/" + hour.Format(time.RFC3339)
+	if f, ok := s.files[key]; ok {
+		return f, nil
+	}
+
+	if receiver == "" {
+		receiver = "default"
+	}
+	dir := filepath.Join(s.config.Dir,
+		"date="+hour.Format("2006-01-02"),
+		"receiver="+url.PathEscape(receiver),
+	)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create Parquet partition: %w", err)
+	}
+
+	// A restart within the hour starts a second file rather than replacing
+	// the first
+	base := "aircraft-" + hour.Format("2006010215")
+	path := filepath.Join(dir, base+".parquet")
+	for n := 1; exists(path) || exists(path+partialSuffix) || exists(path+incompleteSuffix); n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.parquet", base, n))
+	}
+
+	out, err := os.OpenFile(path+partialSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Parquet file: %w", err)
+	}
+
+	f := &file{
+		path:    path,
+		hour:    hour,
+		f:       out,
+		flushed: time.Now(),
+		writer: parquetgo.NewGenericWriter[Row](out,
+			parquetgo.Compression(s.codec),
+			parquetgo.MaxRowsPerRowGroup(s.config.RowGroupSize),
+			parquetgo.CreatedBy("adsb2loki", "1.0.0", ""),
+		),
+	}
+	s.files[key] = f
+
+	logging.Info("Opened Parquet file", "file", path+partialSuffix)
+	return f, nil
+}
+
+// roll completes every file whose hour is before the given one. A file
+// stays open until it is done with, so a rename that failed is tried again
+// on the next roll.
+func (s *Sink) roll(current time.Time) error {
+	var errs []error
+	for key, f := range s.files {
+		if !f.hour.Before(current) {
+			continue
+		}
+		if err := f.close(); err != nil {
+			errs = append(errs, err)
+		}
+		if f.done {
+			delete(s.files, key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// close writes the footer and gives the file its final name. A file whose
+// footer can't be written is unreadable, so it is set aside as incomplete
+// rather than being left partial for the next start to clean up.
+func (f *file) close() error {
+	if f.writer != nil {
+		err := f.writer.Close()
+		if closeErr := f.f.Close(); err == nil {
+			err = closeErr
+		}
+		f.writer, f.f = nil, nil
+		if err != nil {
+			f.done = true
+			logging.Error("Failed to complete Parquet file", "error", err, "file", f.path)
+			setAside(f.path + partialSuffix)
+			return fmt.Errorf("failed to complete Parquet file: %w", err)
+		}
+	}
+
+	if err := os.Rename(f.path+partialSuffix, f.path); err != nil {
+		logging.Error("Failed to rename completed Parquet file", "error", err, "file", f.path)
+		return fmt.Errorf("failed to complete Parquet file: %w", err)
+	}
+	f.done = true
+
+	logging.Info("Completed Parquet file", "file", f.path, "rows", f.rows)
+	return nil
+}
+
+// Flush writes the rows buffered for each open file as a row group. The
+// files stay partial until they are completed.
+func (s *Sink) Flush(ctx context.Context) error {
+	var errs []error
+	for _, f := range s.files {
+		if f.writer == nil {
+			continue
+		}
+		if err := f.writer.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush %s: %w", f.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close completes every open file.
+func (s *Sink) Close() error {
+	var errs []error
+	for key, f := range s.files {
+		if err := f.close(); err != nil {
+			errs = append(errs, err)
+		}
+		if f.done {
+			delete(s.files, key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setAsidePartial sets aside the partial files a crash left behind. Without
+// a footer their rows can't be read back by queries globbing the dataset.
+func setAsidePartial(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, partialSuffix) {
+			return err
+		}
+		logging.Warn("Found partial Parquet file left by an unclean shutdown", "file", path)
+		setAside(path)
+		return nil
+	})
+	if err != nil {
+		logging.Error("Failed to look for partial Parquet files", "error", err, "dir", dir)
+	}
+}
+
+// setAside renames a partial file to <name>.incomplete.
+func setAside(partial string) {
+	incomplete := strings.TrimSuffix(partial, partialSuffix) + incompleteSuffix
+	if err := os.Rename(partial, incomplete); err != nil {
+		logging.Error("Failed to set aside incomplete Parquet file", "error", err, "file", partial)
+		return
+	}
+	logging.Warn("Set aside incomplete Parquet file", "file", incomplete)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,193 @@
+package parquet
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+	"github.com/burnettdev/adsb2loki/pkg/sink"
+)
+
+// Row is the schema of the aircraft files: one row per aircraft per polling
+// cycle. The columns follow models.Aircraft and are listed explicitly, so the
+// schema only changes when this struct does, never because a receiver sent a
+// field it hadn't before. Fields the model doesn't know about, and the
+// reasons an invalid aircraft was rejected, are kept as JSON in "extra".
+type Row struct {
+	Time     time.Time `parquet:"time,timestamp(millisecond)"`
+	Receiver string    `parquet:"receiver,dict"`
+	Band     string    `parquet:"band,dict"`
+	Source   string    `parquet:"source,dict"`
+	Phase    string    `parquet:"phase,optional,dict"`
+	Watch    string    `parquet:"watch,optional,dict"`
+	Invalid  bool      `parquet:"invalid"`
+
+	Hex            string   `parquet:"hex"`
+	Type           string   `parquet:"type,optional,dict"`
+	Flight         string   `parquet:"flight,optional"`
+	R              string   `parquet:"r,optional"`
+	T              string   `parquet:"t,optional,dict"`
+	Desc           string   `parquet:"desc,optional,dict"`
+	OwnOp          string   `parquet:"own_op,optional"`
+	Year           string   `parquet:"year,optional"`
+	DbFlags        int      `parquet:"db_flags"`
+	AltBaro        *int     `parquet:"alt_baro"`
+	Ground         bool     `parquet:"ground"`
+	AltGeom        *int     `parquet:"alt_geom"`
+	Gs             *float64 `parquet:"gs"`
+	Ias            *int     `parquet:"ias"`
+	Tas            *int     `parquet:"tas"`
+	Mach           *float64 `parquet:"mach"`
+	Wd             *int     `parquet:"wd"`
+	Ws             *int     `parquet:"ws"`
+	Oat            *int     `parquet:"oat"`
+	Tat            *int     `parquet:"tat"`
+	Track          *float64 `parquet:"track"`
+	TrackRate      *float64 `parquet:"track_rate"`
+	Roll           *float64 `parquet:"roll"`
+	MagHeading     *float64 `parquet:"mag_heading"`
+	TrueHeading    *float64 `parquet:"true_heading"`
+	CalcTrack      *float64 `parquet:"calc_track"`
+	BaroRate       *int     `parquet:"baro_rate"`
+	GeomRate       *int     `parquet:"geom_rate"`
+	Squawk         string   `parquet:"squawk,optional"`
+	Emergency      string   `parquet:"emergency,optional,dict"`
+	Category       string   `parquet:"category,optional,dict"`
+	NavQnh         *float64 `parquet:"nav_qnh"`
+	NavAltitudeMcp *int     `parquet:"nav_altitude_mcp"`
+	NavAltitudeFms *int     `parquet:"nav_altitude_fms"`
+	NavHeading     *float64 `parquet:"nav_heading"`
+	NavModes       []string `parquet:"nav_modes,list"`
+	Lat            *float64 `parquet:"lat"`
+	Lon            *float64 `parquet:"lon"`
+	Nic            *int     `parquet:"nic"`
+	Rc             *int     `parquet:"rc"`
+	SeenPos        *float64 `parquet:"seen_pos"`
+	RDst           *float64 `parquet:"r_dst"`
+	RDir           *float64 `parquet:"r_dir"`
+	Version        *int     `parquet:"version"`
+	NicBaro        *int     `parquet:"nic_baro"`
+	NacP           *int     `parquet:"nac_p"`
+	NacV           *int     `parquet:"nac_v"`
+	Sil            *int     `parquet:"sil"`
+	SilType        string   `parquet:"sil_type,optional,dict"`
+	Gva            *int     `parquet:"gva"`
+	Sda            *int     `parquet:"sda"`
+	Alert          *int     `parquet:"alert"`
+	Spi            *int     `parquet:"spi"`
+	Mlat           []string `parquet:"mlat,list"`
+	Tisb           []string `parquet:"tisb,list"`
+	Messages       int      `parquet:"messages"`
+	Seen           float64  `parquet:"seen"`
+	Rssi           *float64 `parquet:"rssi"`
+	ReceiverCount  *int     `parquet:"receiver_count"`
+	LastPosLat     *float64 `parquet:"last_position_lat"`
+	LastPosLon     *float64 `parquet:"last_position_lon"`
+	LastPosSeenPos *float64 `parquet:"last_position_seen_pos"`
+	AcasRA         string   `parquet:"acas_ra,optional"`
+	Extra          string   `parquet:"extra,optional"`
+}
+
+// newRow flattens an aircraft record.
+func newRow(r sink.Record) (Row, error) {
+	a := r.Aircraft
+	row := Row{
+		Time:     r.Timestamp.UTC(),
+		Receiver: r.Receiver,
+		Band:     r.Labels["band"],
+		Source:   string(a.Source()),
+		Phase:    r.Labels["phase"],
+		Watch:    r.Labels["watch"],
+		Invalid:  r.Labels["quality"] == "invalid",
+
+		Hex:            a.Hex,
+		Type:           a.Type,
+		Flight:         a.Flight,
+		R:              a.R,
+		T:              a.T,
+		Desc:           a.Desc,
+		OwnOp:          a.OwnOp,
+		Year:           a.Year,
+		DbFlags:        a.DbFlags,
+		AltBaro:        a.AltBaro,
+		Ground:         a.Ground,
+		AltGeom:        a.AltGeom,
+		Gs:             a.Gs,
+		Ias:            a.Ias,
+		Tas:            a.Tas,
+		Mach:           a.Mach,
+		Wd:             a.Wd,
+		Ws:             a.Ws,
+		Oat:            a.Oat,
+		Tat:            a.Tat,
+		Track:          a.Track,
+		TrackRate:      a.TrackRate,
+		Roll:           a.Roll,
+		MagHeading:     a.MagHeading,
+		TrueHeading:    a.TrueHeading,
+		CalcTrack:      a.CalcTrack,
+		BaroRate:       a.BaroRate,
+		GeomRate:       a.GeomRate,
+		Squawk:         a.Squawk,
+		Emergency:      a.Emergency,
+		Category:       a.Category,
+		NavQnh:         a.NavQnh,
+		NavAltitudeMcp: a.NavAltitudeMcp,
+		NavAltitudeFms: a.NavAltitudeFms,
+		NavHeading:     a.NavHeading,
+		NavModes:       a.NavModes,
+		Lat:            a.Lat,
+		Lon:            a.Lon,
+		Nic:            a.Nic,
+		Rc:             a.Rc,
+		SeenPos:        a.SeenPos,
+		RDst:           a.RDst,
+		RDir:           a.RDir,
+		Version:        a.Version,
+		NicBaro:        a.NicBaro,
+		NacP:           a.NacP,
+		NacV:           a.NacV,
+		Sil:            a.Sil,
+		SilType:        a.SilType,
+		Gva:            a.Gva,
+		Sda:            a.Sda,
+		Alert:          a.Alert,
+		Spi:            a.Spi,
+		Mlat:           fieldNames(a.Mlat),
+		Tisb:           fieldNames(a.Tisb),
+		Messages:       a.Messages,
+		Seen:           a.Seen,
+		Rssi:           a.Rssi,
+		ReceiverCount:  a.ReceiverCount,
+	}
+
+	if p := a.LastPosition; p != nil {
+		row.LastPosLat = &p.Lat
+		row.LastPosLon = &p.Lon
+		row.LastPosSeenPos = &p.SeenPos
+	}
+	if a.AcasRA != nil {
+		ra, err := json.Marshal(a.AcasRA)
+		if err != nil {
+			return Row{}, fmt.Errorf("failed to marshal acas_ra: %w", err)
+		}
+		row.AcasRA = string(ra)
+	}
+	if len(a.Extra) > 0 {
+		extra, err := json.Marshal(a.Extra)
+		if err != nil {
+			return Row{}, fmt.Errorf("failed to marshal extra fields: %w", err)
+		}
+		row.Extra = string(extra)
+	}
+	return row, nil
+}
+
+func fieldNames(s models.FieldSet) []string {
+	names := s.Names()
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
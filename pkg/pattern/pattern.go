@@ -0,0 +1,374 @@
+package pattern
+
+import (
+	"math"
+
+	"github.com/burnettdev/adsb2loki/pkg/geo"
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Pattern is a recognised flight pattern, also used as the event type.
+type Pattern string
+
+const (
+	None    Pattern = ""
+	Orbit   Pattern = "orbit"
+	Holding Pattern = "holding"
+	Survey  Pattern = "survey"
+)
+
+const (
+	// window is how many seconds of track history are kept per aircraft
+	window = 1200.0
+	// minDuration is the shortest history, in seconds, a pattern is
+	// recognised in
+	minDuration = 180.0
+	// maxGap is the longest interval, in seconds, between two samples that
+	// is still integrated into the turn; longer gaps break the history
+	maxGap = 60.0
+
+	// straightRate is the turn rate, in degrees per second, below which the
+	// aircraft is flying a straight leg, and minLeg the shortest leg in seconds
+	straightRate = 1.0
+	minLeg       = 30.0
+	// reversalTolerance is how far, in degrees, two legs may be from
+	// exactly opposite headings to count as a reversal
+	reversalTolerance = 30.0
+
+	// Orbits and holds need minTurns full turns in one direction, making up
+	// at least minConsistency of all turning
+	minTurns       = 2.0
+	minConsistency = 0.8
+	// maxOrbitRadius is the largest mean distance from the centre, in
+	// nautical miles, of an orbit
+	maxOrbitRadius = 3.0
+	// maxOrbitStraight is the largest share of time an orbit spends on
+	// straight legs
+	maxOrbitStraight = 0.3
+	// A survey needs minSurveyLegs alternating parallel legs and little net
+	// turn
+	minSurveyLegs        = 4
+	maxSurveyConsistency = 0.5
+
+	// repeat is how often, in seconds, an ongoing pattern is reported again
+	// with its updated duration and turn count
+	repeat = 600.0
+	// timeout is how long, in seconds, an unheard aircraft is remembered
+	timeout = 600.0
+)
+
+// Detector accumulates the recent track history of each aircraft and
+// recognises orbits, holding patterns and survey grids in it. It is not safe
+// for concurrent use.
+type Detector struct {
+	aircraft map[string]*history
+}
+
+type history struct {
+	samples []sample
+	updated float64
+
+	// active is the pattern currently reported, since when it has been
+	// flown and the cumulative turn at that point, and when it was last
+	// reported
+	active    Pattern
+	since     float64
+	sinceTurn float64
+	reported  float64
+}
+
+type sample struct {
+	t, lat, lon float64
+	track       float64
+	// turn is the cumulative signed turn in degrees since the first sample,
+	// positive to the right
+	turn float64
+	// rate is the turn rate in degrees per second leading up to the sample
+	rate float64
+}
+
+func NewDetector() *Detector {
+	logging.DebugCall("pattern.NewDetector")
+
+	return &Detector{aircraft: make(map[string]*history)}
+}
+
+// Update adds a report of the aircraft to its history and returns an event
+// when it starts flying a pattern, or has kept flying one for a while.
+func (d *Detector) Update(a models.Aircraft, now float64) (models.Event, bool) {
+	h := d.aircraft[a.Hex]
+	if h == nil {
+		h = &history{}
+		d.aircraft[a.Hex] = h
+	}
+	if now <= h.updated {
+		return models.Event{}, false
+	}
+	h.updated = now
+
+	if a.Ground || !a.HasPosition() {
+		h.samples, h.active = nil, None
+		return models.Event{}, false
+	}
+
+	h.add(a, now)
+
+	stats := h.analyse()
+	if stats.pattern == None {
+		h.active = None
+		return models.Event{}, false
+	}
+
+	if stats.pattern != h.active {
+		h.active, h.since, h.sinceTurn = stats.pattern, stats.start, h.samples[0].turn
+	} else if now-h.reported < repeat {
+		return models.Event{}, false
+	}
+	h.reported = now
+
+	event := models.NewEvent(string(stats.pattern), now, a)
+	event.Lat, event.Lon = models.Ptr(round(stats.centerLat, 5)), models.Ptr(round(stats.centerLon, 5))
+	event.Details = map[string]interface{}{
+		"center_lat": round(stats.centerLat, 5),
+		"center_lon": round(stats.centerLon, 5),
+		"radius_nm":  round(stats.radius, 2),
+		"duration_s": math.Round(now - h.since),
+	}
+	if stats.pattern == Survey {
+		event.Details["legs"] = stats.legs
+	} else {
+		turn := h.samples[len(h.samples)-1].turn - h.sinceTurn
+		event.Details["turns"] = round(math.Abs(turn)/360, 1)
+		event.Details["direction"] = direction(turn)
+	}
+
+	logging.Debug("Flight pattern detected", "hex", a.Hex, "pattern", stats.pattern, "turns", event.Details["turns"], "radius_nm", event.Details["radius_nm"])
+	return event, true
+}
+
+// Expire forgets aircraft that haven't been updated for a while.
+func (d *Detector) Expire(now float64) {
+	for hex, h := range d.aircraft {
+		if now-h.updated > timeout {
+			delete(d.aircraft, hex)
+		}
+	}
+}
+
+// add appends a position to the history, working out the turn since the
+// previous one from TrackRate, then Roll, then the change in track.
+func (h *history) add(a models.Aircraft, now float64) {
+	s := sample{
+		t:   now - models.Deref(a.SeenPos),
+		lat: *a.Lat,
+		lon: *a.Lon,
+	}
+
+	var previous *sample
+	if n := len(h.samples); n > 0 {
+		previous = &h.samples[n-1]
+		if s.t <= previous.t {
+			return
+		}
+		if s.t-previous.t > maxGap {
+			h.samples, previous = nil, nil
+		}
+	}
+
+	switch {
+	case a.Track != nil:
+		s.track = *a.Track
+	case a.TrueHeading != nil:
+		s.track = *a.TrueHeading
+	case previous != nil:
+		s.track = geo.Bearing(previous.lat, previous.lon, s.lat, s.lon)
+	}
+
+	if previous != nil {
+		dt := s.t - previous.t
+		if rate, ok := turnRate(a); ok {
+			s.rate = rate
+		} else {
+			s.rate = signedDifference(previous.track, s.track) / dt
+		}
+		s.turn = previous.turn + s.rate*dt
+	}
+
+	h.samples = append(h.samples, s)
+
+	// Drop history that has slid out of the window
+	cut := 0
+	for cut < len(h.samples) && s.t-h.samples[cut].t > window {
+		cut++
+	}
+	h.samples = h.samples[cut:]
+}
+
+// turnRate returns the reported turn rate in degrees per second, positive to
+// the right, or one derived from the roll angle and airspeed.
+func turnRate(a models.Aircraft) (float64, bool) {
+	if a.TrackRate != nil {
+		return *a.TrackRate, true
+	}
+
+	speed := models.Deref(a.Gs)
+	if a.Tas != nil {
+		speed = float64(*a.Tas)
+	}
+	if a.Roll != nil && speed > 0 {
+		// Rate of a coordinated turn: g·tan(bank)/v, in degrees per second
+		// with v in knots
+		return 1091 * math.Tan(*a.Roll*math.Pi/180) / speed, true
+	}
+	return 0, false
+}
+
+type stats struct {
+	pattern              Pattern
+	start                float64
+	centerLat, centerLon float64
+	radius               float64
+	netTurn              float64
+	legs                 int
+}
+
+type leg struct {
+	start, end   int
+	heading      float64
+	turnAtStart  float64
+	turnAtFinish float64
+}
+
+// analyse classifies the history as an orbit, holding pattern or survey.
+func (h *history) analyse() stats {
+	var result stats
+
+	n := len(h.samples)
+	if n < 2 || h.samples[n-1].t-h.samples[0].t < minDuration {
+		return result
+	}
+	first, last := h.samples[0], h.samples[n-1]
+	result.start = first.t
+
+	// Longitudes are averaged as unit vectors, so a pattern flown across the
+	// antimeridian is centred on it rather than on the far side of the globe
+	var totalTurn, straightTime, lonSin, lonCos float64
+	for i := 1; i < n; i++ {
+		dt := h.samples[i].t - h.samples[i-1].t
+		totalTurn += math.Abs(h.samples[i].rate * dt)
+		if math.Abs(h.samples[i].rate) < straightRate {
+			straightTime += dt
+		}
+		result.centerLat += h.samples[i].lat
+		lonSin += math.Sin(h.samples[i].lon * math.Pi / 180)
+		lonCos += math.Cos(h.samples[i].lon * math.Pi / 180)
+	}
+	result.centerLat /= float64(n - 1)
+	result.centerLon = math.Atan2(lonSin, lonCos) * 180 / math.Pi
+
+	for _, s := range h.samples[1:] {
+		result.radius += geo.Distance(result.centerLat, result.centerLon, s.lat, s.lon)
+	}
+	result.radius /= float64(n - 1)
+
+	result.netTurn = last.turn - first.turn
+	if totalTurn == 0 {
+		return result
+	}
+	consistency := math.Abs(result.netTurn) / totalTurn
+	straight := straightTime / (last.t - first.t)
+
+	legs := h.legs()
+	result.legs = len(legs)
+
+	switch {
+	case math.Abs(result.netTurn) >= minTurns*360 && consistency >= minConsistency:
+		if result.radius <= maxOrbitRadius && straight <= maxOrbitStraight {
+			result.pattern = Orbit
+		} else if reversals(legs, false) >= 2 {
+			result.pattern = Holding
+		} else if result.radius <= maxOrbitRadius {
+			result.pattern = Orbit
+		}
+	case consistency <= maxSurveyConsistency && reversals(legs, true) >= minSurveyLegs-1:
+		result.pattern = Survey
+	}
+
+	return result
+}
+
+// legs splits the history into straight legs of at least minLeg seconds.
+func (h *history) legs() []leg {
+	var (
+		legs  []leg
+		start = -1
+	)
+
+	closeLeg := func(end int) {
+		if start >= 0 && h.samples[end].t-h.samples[start].t >= minLeg {
+			legs = append(legs, leg{
+				start:        start,
+				end:          end,
+				heading:      h.samples[start].track,
+				turnAtStart:  h.samples[start].turn,
+				turnAtFinish: h.samples[end].turn,
+			})
+		}
+		start = -1
+	}
+
+	for i := 1; i < len(h.samples); i++ {
+		if math.Abs(h.samples[i].rate) < straightRate {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		closeLeg(i - 1)
+	}
+	closeLeg(len(h.samples) - 1)
+
+	return legs
+}
+
+// reversals counts successive legs flown in opposite directions. A survey's
+// turns alternate left and right while a hold's all go the same way.
+func reversals(legs []leg, alternating bool) int {
+	count := 0
+	var previousTurn float64
+	for i := 1; i < len(legs); i++ {
+		if geo.HeadingDifference(legs[i].heading, legs[i-1].heading) < 180-reversalTolerance {
+			continue
+		}
+		turn := legs[i].turnAtStart - legs[i-1].turnAtFinish
+		if previousTurn != 0 && (turn*previousTurn < 0) != alternating {
+			continue
+		}
+		previousTurn = turn
+		count++
+	}
+	return count
+}
+
+// signedDifference returns the change from one heading to another in
+// degrees (-180, 180], positive to the right.
+func signedDifference(from, to float64) float64 {
+	d := geo.NormalizeHeading(to - from)
+	if d > 180 {
+		d -= 360
+	}
+	return d
+}
+
+func direction(turn float64) string {
+	if turn < 0 {
+		return "left"
+	}
+	return "right"
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
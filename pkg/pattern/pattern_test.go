@@ -0,0 +1,192 @@
+package pattern
+
+import (
+	"math"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/geo"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// start is when each flight begins. Snapshot times are Unix times, so the
+// detector never sees zero.
+const start = 1_700_000_000
+
+const (
+	// step is the polling interval, in seconds, of the simulated flights
+	step = 5.0
+	// speed is their ground speed in knots
+	speed = 200.0
+	// rate is a standard rate turn in degrees per second
+	rate = 3.0
+)
+
+// segment is part of a simulated flight: seconds flown at a turn rate in
+// degrees per second, positive to the right.
+type segment struct {
+	seconds, rate float64
+}
+
+type position struct {
+	now      float64
+	aircraft models.Aircraft
+}
+
+// fly simulates a flight from a position and heading through the segments,
+// reporting every step seconds.
+func fly(lat, lon, heading float64, segments ...segment) []position {
+	var (
+		positions []position
+		now       = float64(start)
+	)
+	for _, seg := range segments {
+		for elapsed := 0.0; elapsed < seg.seconds; elapsed += step {
+			heading = geo.NormalizeHeading(heading + seg.rate*step)
+			distance := speed / 3600 * step
+			lat += distance * math.Cos(heading*math.Pi/180) / 60
+			lon += distance * math.Sin(heading*math.Pi/180) / (60 * math.Cos(lat*math.Pi/180))
+			if lon > 180 {
+				lon -= 360
+			} else if lon <= -180 {
+				lon += 360
+			}
+			now += step
+
+			positions = append(positions, position{now, models.Aircraft{
+				Hex:   "4ca1fa",
+				Lat:   models.Ptr(lat),
+				Lon:   models.Ptr(lon),
+				Track: models.Ptr(heading),
+				Gs:    models.Ptr(speed),
+			}})
+		}
+	}
+	return positions
+}
+
+func detect(positions []position) []models.Event {
+	d := NewDetector()
+	var events []models.Event
+	for _, p := range positions {
+		if e, ok := d.Update(p.aircraft, p.now); ok {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// racetrack is one circuit of a holding pattern with one-minute legs.
+func racetrack(turn float64) []segment {
+	return []segment{{60, 0}, {60, turn}, {60, 0}, {60, turn}}
+}
+
+func circuits(n int, segments []segment) []segment {
+	var all []segment
+	for i := 0; i < n; i++ {
+		all = append(all, segments...)
+	}
+	return all
+}
+
+// lawnmower flies parallel legs joined by alternating 180° turns.
+func lawnmower(legs int) []segment {
+	var segments []segment
+	turn := rate
+	for i := 0; i < legs; i++ {
+		if i > 0 {
+			segments = append(segments, segment{60, turn})
+			turn = -turn
+		}
+		segments = append(segments, segment{90, 0})
+	}
+	return segments
+}
+
+func TestPatterns(t *testing.T) {
+	tests := map[string]struct {
+		positions []position
+		want      Pattern
+		direction string
+	}{
+		"orbit to the right":        {fly(51, -1, 0, segment{400, rate}), Orbit, "right"},
+		"orbit to the left":         {fly(51, -1, 0, segment{400, -rate}), Orbit, "left"},
+		"holding":                   {fly(51, -1, 90, circuits(3, racetrack(rate))...), Holding, "right"},
+		"survey":                    {fly(51, -1, 0, lawnmower(6)...), Survey, ""},
+		"straight and level":        {fly(51, -1, 90, segment{900, 0}), None, ""},
+		"a single turn":             {fly(51, -1, 90, segment{90, 0}, segment{120, rate}, segment{300, 0}), None, ""},
+		"orbit too short":           {fly(51, -1, 0, segment{150, rate}), None, ""},
+		"orbit on the antimeridian": {fly(-17, 179.99, 0, segment{400, rate}), Orbit, "right"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			events := detect(tt.positions)
+			if tt.want == None {
+				if len(events) > 0 {
+					t.Errorf("detected %q", events[0].Type)
+				}
+				return
+			}
+			if len(events) == 0 {
+				t.Fatalf("no pattern detected, want %q", tt.want)
+			}
+			for _, e := range events {
+				if e.Type != string(tt.want) {
+					t.Errorf("detected %q, want %q", e.Type, tt.want)
+				}
+				if tt.direction != "" && e.Details["direction"] != tt.direction {
+					t.Errorf("direction = %v, want %s", e.Details["direction"], tt.direction)
+				}
+			}
+		})
+	}
+}
+
+// An orbit's centre is where it was flown, even across the antimeridian,
+// where a plain average of longitudes lands on the far side of the globe.
+func TestOrbitCentre(t *testing.T) {
+	tests := map[string]struct {
+		lat, lon, heading float64
+	}{
+		"London":                  {51, -1, 0},
+		"antimeridian, from west": {-17, 179.99, 0},
+		"antimeridian, from east": {-17, -179.99, 180},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// A right turn circles a centre one radius east when starting
+			// north, and one radius west when starting south
+			positions := fly(tt.lat, tt.lon, tt.heading, segment{400, rate})
+			radius := speed / 3600 / (rate * math.Pi / 180)
+			lat := tt.lat
+			lon := tt.lon + math.Cos(tt.heading*math.Pi/180)*radius/(60*math.Cos(lat*math.Pi/180))
+
+			events := detect(positions)
+			if len(events) == 0 {
+				t.Fatal("no orbit detected")
+			}
+			e := events[0]
+			if d := geo.Distance(lat, lon, *e.Lat, *e.Lon); d > 0.2 {
+				t.Errorf("centre = %v, %v, %.2f nm from %v, %v", *e.Lat, *e.Lon, d, lat, lon)
+			}
+			if r := e.Details["radius_nm"].(float64); math.Abs(r-radius) > 0.1 {
+				t.Errorf("radius = %v nm, want about %.2f", r, radius)
+			}
+			if *e.Lon > 180 || *e.Lon <= -180 {
+				t.Errorf("centre longitude = %v, want it within (-180, 180]", *e.Lon)
+			}
+		})
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	events := detect(fly(51, -1, 0, segment{1000, rate}))
+	if len(events) != 2 {
+		t.Fatalf("reported %d times over 1000 s, want 2", len(events))
+	}
+	if gap := events[1].Time - events[0].Time; gap < repeat {
+		t.Errorf("reported again after %v s, want at least %v", gap, repeat)
+	}
+	if turns := events[1].Details["turns"].(float64); turns <= events[0].Details["turns"].(float64) {
+		t.Errorf("turns = %v, want more than the first report", turns)
+	}
+}
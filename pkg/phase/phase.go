@@ -0,0 +1,245 @@
+package phase
+
+import (
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Phase is the flight phase an aircraft is in.
+type Phase string
+
+const (
+	Unknown  Phase = ""
+	Ground   Phase = "ground"
+	Takeoff  Phase = "takeoff"
+	Climb    Phase = "climb"
+	Cruise   Phase = "cruise"
+	Descent  Phase = "descent"
+	Approach Phase = "approach"
+	Landing  Phase = "landing"
+	GoAround Phase = "go_around"
+)
+
+// EventPhaseChange is the event type emitted when an aircraft changes phase.
+const EventPhaseChange = "phase_change"
+
+const (
+	// levelRate is the vertical rate, in ft/min, above which an aircraft is
+	// climbing or descending rather than level
+	levelRate = 500
+	// approachCeiling is the altitude, in feet, below which a descent is
+	// treated as an approach and a climb out of one as a go-around
+	approachCeiling = 5000
+	// takeoffHeight and takeoffTime bound the takeoff phase after liftoff
+	takeoffHeight = 1500
+	takeoffTime   = 120.0
+	// rollSpeed is the ground speed, in knots, above which an aircraft on
+	// the ground is on its takeoff or landing roll rather than taxiing
+	rollSpeed = 40.0
+	// landingTime bounds the landing phase after touchdown
+	landingTime = 120.0
+	// minRateInterval is the shortest interval, in seconds, over which a
+	// vertical rate is measured when none is reported
+	minRateInterval = 5.0
+	// confirmations is how many successive reports a change between climb,
+	// cruise and descent needs, so a brief level-off doesn't flap the phase
+	confirmations = 2
+	// timeout is how long, in seconds, an unheard aircraft is remembered
+	timeout = 600.0
+)
+
+// Tracker classifies aircraft into flight phases. The phase depends on what
+// the aircraft was doing before, so it keeps state between polling cycles
+// and is not safe for concurrent use.
+type Tracker struct {
+	aircraft map[string]*state
+}
+
+type state struct {
+	phase   Phase
+	updated float64
+
+	pending      Phase
+	pendingCount int
+
+	// liftoff and touchdown are when the aircraft last left or reached the
+	// ground, and liftoffAlt its first altitude once airborne
+	liftoff    float64
+	liftoffAlt int
+	touchdown  float64
+
+	alt     *int
+	altTime float64
+}
+
+func NewTracker() *Tracker {
+	logging.DebugCall("phase.NewTracker")
+
+	return &Tracker{aircraft: make(map[string]*state)}
+}
+
+// Update classifies the aircraft from a snapshot taken at now and returns
+// its phase, along with the previous phase when it changed. Reports of the
+// same aircraft from several receivers in one snapshot are classified once.
+func (t *Tracker) Update(a models.Aircraft, now float64) (current, previous Phase, changed bool) {
+	s := t.aircraft[a.Hex]
+	if s == nil {
+		s = &state{}
+		t.aircraft[a.Hex] = s
+	}
+	if now <= s.updated {
+		return s.phase, s.phase, false
+	}
+	s.updated = now
+
+	next := s.classify(a, now)
+	s.observeAltitude(a, now)
+
+	if next == s.phase || next == Unknown {
+		s.pending, s.pendingCount = Unknown, 0
+		return s.phase, s.phase, false
+	}
+
+	if needsConfirmation(s.phase, next) {
+		if s.pending != next {
+			s.pending, s.pendingCount = next, 0
+		}
+		s.pendingCount++
+		if s.pendingCount < confirmations {
+			return s.phase, s.phase, false
+		}
+	}
+
+	previous = s.phase
+	s.phase = next
+	s.pending, s.pendingCount = Unknown, 0
+
+	logging.Debug("Flight phase changed", "hex", a.Hex, "from", previous, "to", next)
+	return next, previous, previous != Unknown
+}
+
+// Expire forgets aircraft that haven't been updated for a while.
+func (t *Tracker) Expire(now float64) {
+	for hex, s := range t.aircraft {
+		if now-s.updated > timeout {
+			delete(t.aircraft, hex)
+		}
+	}
+}
+
+func (s *state) classify(a models.Aircraft, now float64) Phase {
+	gs := models.Deref(a.Gs)
+	alt, hasAlt := altitude(a)
+
+	onGround := a.Ground || (!hasAlt && a.Gs != nil && gs < rollSpeed)
+	if onGround {
+		wasAirborne := airborne(s.phase) || (s.phase == Takeoff && s.liftoff > 0)
+		s.liftoff = 0
+
+		switch {
+		case wasAirborne:
+			s.touchdown = now
+			return Landing
+		case s.phase == Landing && gs >= rollSpeed && now-s.touchdown <= landingTime:
+			return Landing
+		case s.phase != Landing && gs >= rollSpeed:
+			// Takeoff roll
+			return Takeoff
+		default:
+			return Ground
+		}
+	}
+
+	if !hasAlt {
+		return Unknown
+	}
+
+	if (s.phase == Ground || s.phase == Takeoff) && s.liftoff == 0 {
+		s.liftoff, s.liftoffAlt = now, alt
+	}
+
+	rate, hasRate := s.verticalRate(a, alt, now)
+
+	switch {
+	case s.phase == Takeoff && s.liftoff > 0 && now-s.liftoff <= takeoffTime && alt < s.liftoffAlt+takeoffHeight && (!hasRate || rate >= 0):
+		return Takeoff
+	case s.phase == Ground:
+		return Takeoff
+	case (s.phase == Approach || s.phase == Landing || s.phase == GoAround) && hasRate && rate > levelRate && alt < approachCeiling:
+		return GoAround
+	case s.phase == GoAround && alt < approachCeiling && (!hasRate || rate >= 0):
+		return GoAround
+	case !hasRate:
+		return Unknown
+	case rate > levelRate:
+		return Climb
+	case rate < -levelRate && (alt < approachCeiling || hasNavMode(a, "approach")):
+		return Approach
+	case rate < -levelRate:
+		return Descent
+	case s.phase == Approach && alt < approachCeiling:
+		// Level segments of an approach
+		return Approach
+	default:
+		return Cruise
+	}
+}
+
+// verticalRate returns the reported barometric or geometric rate, or one
+// measured from the previous altitude when neither is reported.
+func (s *state) verticalRate(a models.Aircraft, alt int, now float64) (float64, bool) {
+	if a.BaroRate != nil {
+		return float64(*a.BaroRate), true
+	}
+	if a.GeomRate != nil {
+		return float64(*a.GeomRate), true
+	}
+	if s.alt != nil && now-s.altTime >= minRateInterval {
+		return float64(alt-*s.alt) / (now - s.altTime) * 60, true
+	}
+	return 0, false
+}
+
+func (s *state) observeAltitude(a models.Aircraft, now float64) {
+	alt, ok := altitude(a)
+	if !ok {
+		s.alt = nil
+		return
+	}
+	if s.alt == nil || now-s.altTime >= minRateInterval {
+		s.alt, s.altTime = models.Ptr(alt), now
+	}
+}
+
+func altitude(a models.Aircraft) (int, bool) {
+	if a.AltBaro != nil {
+		return *a.AltBaro, true
+	}
+	if a.AltGeom != nil {
+		return *a.AltGeom, true
+	}
+	return 0, false
+}
+
+func airborne(p Phase) bool {
+	switch p {
+	case Climb, Cruise, Descent, Approach, GoAround:
+		return true
+	default:
+		return false
+	}
+}
+
+func needsConfirmation(from, to Phase) bool {
+	enRoute := func(p Phase) bool { return p == Climb || p == Cruise || p == Descent }
+	return enRoute(from) && enRoute(to)
+}
+
+func hasNavMode(a models.Aircraft, mode string) bool {
+	for _, m := range a.NavModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
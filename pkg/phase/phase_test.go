@@ -0,0 +1,134 @@
+package phase
+
+import (
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// start is when each test begins. Snapshot times are Unix times, so the
+// tracker never sees zero.
+const start = 1_700_000_000
+
+type report struct {
+	now    float64
+	ground bool
+	gs     float64
+	alt    int
+	rate   int
+	want   Phase
+}
+
+func (r report) aircraft() models.Aircraft {
+	a := models.Aircraft{Hex: "4ca1fa", Ground: r.ground, Gs: models.Ptr(r.gs)}
+	if !r.ground {
+		a.AltBaro, a.BaroRate = models.Ptr(r.alt), models.Ptr(r.rate)
+	}
+	return a
+}
+
+func TestPhases(t *testing.T) {
+	tests := map[string][]report{
+		"departure": {
+			{now: 0, ground: true, gs: 5, want: Ground},
+			{now: 10, ground: true, gs: 60, want: Takeoff},
+			{now: 20, gs: 140, alt: 300, rate: 2000, want: Takeoff},
+			{now: 60, gs: 180, alt: 2000, rate: 2500, want: Climb},
+			{now: 600, gs: 450, alt: 35000, rate: 0, want: Climb},
+			{now: 610, gs: 450, alt: 35000, rate: 0, want: Cruise},
+		},
+		"arrival": {
+			{now: 0, gs: 450, alt: 35000, rate: 0, want: Cruise},
+			{now: 10, gs: 450, alt: 34700, rate: -2000, want: Cruise},
+			{now: 20, gs: 450, alt: 34300, rate: -2000, want: Descent},
+			{now: 600, gs: 220, alt: 4000, rate: -1000, want: Approach},
+			{now: 610, gs: 180, alt: 3000, rate: 0, want: Approach},
+			{now: 700, gs: 140, alt: 500, rate: -700, want: Approach},
+			{now: 720, ground: true, gs: 130, want: Landing},
+			{now: 740, ground: true, gs: 80, want: Landing},
+			{now: 900, ground: true, gs: 15, want: Ground},
+		},
+		"go-around": {
+			{now: 0, gs: 160, alt: 2000, rate: -800, want: Approach},
+			{now: 10, gs: 150, alt: 1000, rate: -700, want: Approach},
+			{now: 20, gs: 150, alt: 1200, rate: 1500, want: GoAround},
+			{now: 30, gs: 170, alt: 3000, rate: 0, want: GoAround},
+			{now: 40, gs: 190, alt: 6000, rate: 1500, want: Climb},
+		},
+		"go-around after touchdown": {
+			{now: 0, gs: 140, alt: 500, rate: -700, want: Approach},
+			{now: 10, ground: true, gs: 130, want: Landing},
+			{now: 20, gs: 140, alt: 200, rate: 1500, want: GoAround},
+		},
+		"descent above the ceiling": {
+			{now: 0, gs: 250, alt: 8000, rate: -1000, want: Descent},
+		},
+		// A single level report doesn't interrupt a climb
+		"brief level-off": {
+			{now: 0, gs: 300, alt: 10000, rate: 2000, want: Climb},
+			{now: 10, gs: 300, alt: 10000, rate: 0, want: Climb},
+			{now: 20, gs: 300, alt: 10300, rate: 2000, want: Climb},
+			{now: 30, gs: 300, alt: 10300, rate: 0, want: Climb},
+			{now: 40, gs: 300, alt: 10300, rate: 0, want: Cruise},
+		},
+		// Confirmation only applies between climb, cruise and descent
+		"no confirmation leaving approach": {
+			{now: 0, gs: 160, alt: 6000, rate: 0, want: Cruise},
+			{now: 10, gs: 160, alt: 4500, rate: -900, want: Approach},
+			{now: 20, gs: 160, alt: 6500, rate: 0, want: Cruise},
+		},
+	}
+	for name, reports := range tests {
+		t.Run(name, func(t *testing.T) {
+			tracker := NewTracker()
+			for _, r := range reports {
+				if got, _, _ := tracker.Update(r.aircraft(), start+r.now); got != r.want {
+					t.Errorf("at %v: phase = %q, want %q", r.now, got, r.want)
+				}
+			}
+		})
+	}
+}
+
+func TestApproachMode(t *testing.T) {
+	a := report{gs: 250, alt: 8000, rate: -1000}.aircraft()
+	a.NavModes = []string{"autopilot", "approach"}
+	if got, _, _ := NewTracker().Update(a, start); got != Approach {
+		t.Errorf("phase = %q, want %q with the approach nav mode", got, Approach)
+	}
+}
+
+func TestMeasuredRate(t *testing.T) {
+	tracker := NewTracker()
+	a := models.Aircraft{Hex: "4ca1fa", AltBaro: models.Ptr(10000)}
+	if got, _, _ := tracker.Update(a, start); got != Unknown {
+		t.Errorf("phase = %q without a rate, want unknown", got)
+	}
+	a.AltBaro = models.Ptr(10500)
+	if got, _, _ := tracker.Update(a, start+10); got != Climb {
+		t.Errorf("phase = %q climbing 3000 ft/min, want %q", got, Climb)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	tracker := NewTracker()
+	ground := report{ground: true, gs: 5}.aircraft()
+	roll := report{ground: true, gs: 60}.aircraft()
+
+	if _, _, changed := tracker.Update(ground, start); changed {
+		t.Error("first phase reported as a change")
+	}
+	current, previous, changed := tracker.Update(roll, start+10)
+	if !changed || current != Takeoff || previous != Ground {
+		t.Errorf("Update() = %q, %q, %v, want takeoff, ground, true", current, previous, changed)
+	}
+	// Another receiver's report from the same snapshot
+	if current, _, changed := tracker.Update(ground, start+10); changed || current != Takeoff {
+		t.Errorf("Update() = %q, %v for the same snapshot, want takeoff, false", current, changed)
+	}
+
+	tracker.Expire(start + 10 + timeout + 1)
+	if _, _, changed := tracker.Update(ground, start+700); changed {
+		t.Error("expired aircraft reported a change")
+	}
+}
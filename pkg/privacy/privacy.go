@@ -0,0 +1,215 @@
+package privacy
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// What to do with aircraft that are flagged PIA or LADD, or blocklisted.
+const (
+	ModeOff       = "off"
+	ModeDrop      = "drop"
+	ModeAnonymize = "anonymize"
+)
+
+// anonymousPrefix marks an address replaced by its hash, so it can never be
+// mistaken for a real ICAO address.
+const anonymousPrefix = "anon-"
+
+type Config struct {
+	// Mode is "off" (default), "drop" or "anonymize"
+	Mode string `json:"mode,omitempty"`
+	// Blocklist is a file of addresses and registrations, one per line,
+	// that are treated like PIA/LADD aircraft
+	Blocklist string `json:"blocklist,omitempty"`
+	// Salt keys the hash of anonymized addresses. Without it a random salt
+	// is used, so hashes change on every restart.
+	Salt string `json:"salt,omitempty"`
+	// Grid coarsens every position to a grid of this many degrees
+	Grid float64 `json:"grid,omitempty"`
+}
+
+// Filter drops or anonymizes private aircraft and coarsens positions.
+type Filter struct {
+	mode          string
+	key           []byte
+	grid          float64
+	hexes         map[string]bool
+	registrations map[string]bool
+}
+
+func New(config Config) (*Filter, error) {
+	logging.DebugCall("privacy.New", "mode", config.Mode, "blocklist", config.Blocklist, "grid", config.Grid)
+
+	if config.Mode == "" {
+		config.Mode = ModeOff
+	}
+	if config.Mode != ModeOff && config.Mode != ModeDrop && config.Mode != ModeAnonymize {
+		return nil, fmt.Errorf("unknown privacy mode %q", config.Mode)
+	}
+	if config.Grid < 0 {
+		return nil, fmt.Errorf("privacy grid must not be negative")
+	}
+
+	f := &Filter{
+		mode:          config.Mode,
+		key:           []byte(config.Salt),
+		grid:          config.Grid,
+		hexes:         make(map[string]bool),
+		registrations: make(map[string]bool),
+	}
+
+	if len(f.key) == 0 {
+		f.key = make([]byte, 32)
+		if _, err := rand.Read(f.key); err != nil {
+			return nil, fmt.Errorf("failed to generate privacy salt: %w", err)
+		}
+	}
+
+	if config.Blocklist != "" {
+		if err := f.loadBlocklist(config.Blocklist); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// loadBlocklist reads one address or registration per line. Six hex digits
+// are taken as an address; blank lines and lines starting with # are ignored.
+func (f *Filter) loadBlocklist(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read privacy blocklist: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isHex(line) {
+			f.hexes[strings.ToLower(line)] = true
+		} else {
+			f.registrations[strings.ToUpper(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read privacy blocklist: %w", err)
+	}
+
+	logging.Debug("Privacy blocklist loaded", "path", path, "addresses", len(f.hexes), "registrations", len(f.registrations))
+	return nil
+}
+
+// Private reports whether the aircraft is flagged PIA or LADD, or is on the
+// blocklist.
+func (f *Filter) Private(a models.Aircraft) bool {
+	return a.IsPIA() || a.IsLADD() ||
+		f.hexes[strings.TrimPrefix(a.Hex, "~")] ||
+		(a.R != "" && f.registrations[strings.ToUpper(a.R)])
+}
+
+// Apply handles a private aircraft according to the mode. It returns false
+// when the aircraft must be dropped; otherwise a private aircraft is
+// anonymized in place.
+func (f *Filter) Apply(a *models.Aircraft) bool {
+	if f.mode == ModeOff || !f.Private(*a) {
+		return true
+	}
+	if f.mode == ModeDrop {
+		return false
+	}
+
+	a.Hex = f.anonymize(a.Hex)
+	a.Flight = ""
+	a.R = ""
+	a.OwnOp = ""
+	a.Squawk = ""
+	if a.AcasRA != nil {
+		ra := *a.AcasRA
+		ra.ThreatIDHex = ""
+		a.AcasRA = &ra
+	}
+	return true
+}
+
+// anonymize replaces an address with a keyed hash of it, so an aircraft can
+// still be followed across polls without revealing who it is.
+func (f *Filter) anonymize(address string) string {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(address))
+	return anonymousPrefix + hex.EncodeToString(mac.Sum(nil))[:10]
+}
+
+// CoarsenAircraft snaps every position of the aircraft to the grid. The
+// coordinates are replaced rather than modified, as other copies of the
+// aircraft may share them. Range and bearing from the receiver would give
+// the exact position away again, so they are removed.
+func (f *Filter) CoarsenAircraft(a *models.Aircraft) {
+	if f.grid == 0 {
+		return
+	}
+
+	a.RDst, a.RDir, a.Dst, a.Dir = nil, nil, nil, nil
+	a.Lat, a.Lon = f.snapped(a.Lat), f.snapped(a.Lon)
+	a.RrLat, a.RrLon = f.snapped(a.RrLat), f.snapped(a.RrLon)
+	a.GpsOkLat, a.GpsOkLon = f.snapped(a.GpsOkLat), f.snapped(a.GpsOkLon)
+	if a.LastPosition != nil {
+		last := *a.LastPosition
+		last.Lat, last.Lon = f.snap(last.Lat), f.snap(last.Lon)
+		a.LastPosition = &last
+	}
+}
+
+// CoarsenEvent snaps the event position, and any *_lat or *_lon evidence in
+// its details, to the grid.
+func (f *Filter) CoarsenEvent(e *models.Event) {
+	if f.grid == 0 {
+		return
+	}
+
+	e.Lat, e.Lon = f.snapped(e.Lat), f.snapped(e.Lon)
+	for k, v := range e.Details {
+		if !strings.HasSuffix(k, "_lat") && !strings.HasSuffix(k, "_lon") {
+			continue
+		}
+		if n, ok := v.(float64); ok {
+			e.Details[k] = f.snap(n)
+		}
+	}
+}
+
+func (f *Filter) snapped(p *float64) *float64 {
+	if p == nil {
+		return nil
+	}
+	return models.Ptr(f.snap(*p))
+}
+
+// snap moves a coordinate to the centre of its grid cell.
+func (f *Filter) snap(v float64) float64 {
+	cell := math.Floor(v / f.grid)
+	// Round away the floating point noise of the multiplication
+	return math.Round((cell+0.5)*f.grid*1e6) / 1e6
+}
+
+func isHex(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
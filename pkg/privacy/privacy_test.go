@@ -0,0 +1,220 @@
+package privacy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+func newFilter(t *testing.T, config Config) *Filter {
+	t.Helper()
+
+	f, err := New(config)
+	if err != nil {
+		t.Fatalf("New(%+v) = %v", config, err)
+	}
+	return f
+}
+
+func private() models.Aircraft {
+	return models.Aircraft{
+		Hex:     "a1b2c3",
+		Flight:  "N123AB  ",
+		R:       "N123AB",
+		OwnOp:   "Private Owner",
+		Squawk:  "1200",
+		DbFlags: models.DbFlagPIA,
+		Lat:     models.Ptr(40.1234),
+		Lon:     models.Ptr(-73.5678),
+		AcasRA:  &models.AcasRA{ThreatIDHex: "abc123"},
+	}
+}
+
+func TestApplyModes(t *testing.T) {
+	public := models.Aircraft{Hex: "4ca1fa", Flight: "RYR1AB", DbFlags: models.DbFlagMilitary}
+	ladd := private()
+	ladd.DbFlags = models.DbFlagLADD
+
+	tests := map[string]struct {
+		mode     string
+		aircraft models.Aircraft
+		keep     bool
+		changed  bool
+	}{
+		"off keeps private":         {ModeOff, private(), true, false},
+		"drop drops PIA":            {ModeDrop, private(), false, false},
+		"drop drops LADD":           {ModeDrop, ladd, false, false},
+		"drop keeps public":         {ModeDrop, public, true, false},
+		"anonymize rewrites PIA":    {ModeAnonymize, private(), true, true},
+		"anonymize rewrites LADD":   {ModeAnonymize, ladd, true, true},
+		"anonymize leaves public":   {ModeAnonymize, public, true, false},
+		"default mode is off (PIA)": {"", private(), true, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newFilter(t, Config{Mode: tt.mode, Salt: "salt"})
+			a := tt.aircraft
+			if keep := f.Apply(&a); keep != tt.keep {
+				t.Fatalf("Apply() = %v, want %v", keep, tt.keep)
+			}
+			if changed := a.Hex != tt.aircraft.Hex; changed != tt.changed {
+				t.Errorf("hex %q -> %q, want changed %v", tt.aircraft.Hex, a.Hex, tt.changed)
+			}
+		})
+	}
+}
+
+func TestNewRejectsUnknownMode(t *testing.T) {
+	if _, err := New(Config{Mode: "hide"}); err == nil {
+		t.Error("New() accepted an unknown mode")
+	}
+	if _, err := New(Config{Grid: -1}); err == nil {
+		t.Error("New() accepted a negative grid")
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	f := newFilter(t, Config{Mode: ModeAnonymize, Salt: "salt"})
+
+	a := private()
+	if !f.Apply(&a) {
+		t.Fatal("Apply() dropped an anonymized aircraft")
+	}
+	if !strings.HasPrefix(a.Hex, "anon-") || len(a.Hex) != len("anon-")+10 {
+		t.Errorf("hex = %q, want anon- and 10 hex digits", a.Hex)
+	}
+	if a.Flight != "" || a.R != "" || a.OwnOp != "" || a.Squawk != "" {
+		t.Errorf("flight, r, ownOp, squawk = %q, %q, %q, %q, want all blank", a.Flight, a.R, a.OwnOp, a.Squawk)
+	}
+	if a.AcasRA.ThreatIDHex != "" {
+		t.Errorf("acas_ra threat_id_hex = %q, want blank", a.AcasRA.ThreatIDHex)
+	}
+	// Position is kept; coarsening it is the grid's job
+	if a.Lat == nil || *a.Lat != 40.1234 {
+		t.Errorf("lat = %v, want it kept", a.Lat)
+	}
+
+	shared := private()
+	copied := shared
+	f.Apply(&copied)
+	if shared.AcasRA.ThreatIDHex != "abc123" {
+		t.Error("Apply() modified an acas_ra shared with another copy")
+	}
+
+	// The same key gives the same hash, so the aircraft can be followed
+	// across polls and restarts
+	again := private()
+	newFilter(t, Config{Mode: ModeAnonymize, Salt: "salt"}).Apply(&again)
+	if again.Hex != a.Hex {
+		t.Errorf("same salt gave %q and %q", a.Hex, again.Hex)
+	}
+
+	other := private()
+	newFilter(t, Config{Mode: ModeAnonymize, Salt: "pepper"}).Apply(&other)
+	if other.Hex == a.Hex {
+		t.Errorf("different salts both gave %q", a.Hex)
+	}
+
+	random := private()
+	newFilter(t, Config{Mode: ModeAnonymize}).Apply(&random)
+	if random.Hex == a.Hex || !strings.HasPrefix(random.Hex, "anon-") {
+		t.Errorf("random salt gave %q", random.Hex)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	blocklist := "# private aircraft\n\nABCDEF\n  n456cd  \n"
+	if err := os.WriteFile(path, []byte(blocklist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := newFilter(t, Config{Mode: ModeDrop, Blocklist: path})
+
+	tests := map[string]struct {
+		aircraft models.Aircraft
+		private  bool
+	}{
+		"address, case insensitive": {models.Aircraft{Hex: "abcdef"}, true},
+		"non-ICAO address":          {models.Aircraft{Hex: "~abcdef"}, true},
+		"registration, trimmed":     {models.Aircraft{Hex: "111111", R: "N456CD"}, true},
+		"registration, lower case":  {models.Aircraft{Hex: "111111", R: "n456cd"}, true},
+		"not listed":                {models.Aircraft{Hex: "222222", R: "G-ABCD"}, false},
+		"comment is not an entry":   {models.Aircraft{Hex: "333333", R: "# PRIVATE AIRCRAFT"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := f.Private(tt.aircraft); got != tt.private {
+				t.Errorf("Private(%+v) = %v, want %v", tt.aircraft, got, tt.private)
+			}
+		})
+	}
+
+	if _, err := New(Config{Blocklist: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("New() accepted a missing blocklist")
+	}
+}
+
+func TestCoarsenAircraft(t *testing.T) {
+	f := newFilter(t, Config{Grid: 0.1})
+
+	a := models.Aircraft{
+		Hex:          "4ca1fa",
+		Lat:          models.Ptr(51.4706),
+		Lon:          models.Ptr(-0.4619),
+		RDst:         models.Ptr(12.3),
+		RDir:         models.Ptr(270.0),
+		LastPosition: &models.LastPosition{Lat: 51.0001, Lon: 0.0999},
+	}
+	lat, last := a.Lat, a.LastPosition
+	f.CoarsenAircraft(&a)
+
+	// Cells are snapped to their centre: 51.4706 lies in [51.4, 51.5)
+	if *a.Lat != 51.45 || *a.Lon != -0.45 {
+		t.Errorf("position = %v, %v, want 51.45, -0.45", *a.Lat, *a.Lon)
+	}
+	if a.LastPosition.Lat != 51.05 || a.LastPosition.Lon != 0.05 {
+		t.Errorf("lastPosition = %v, %v, want 51.05, 0.05", a.LastPosition.Lat, a.LastPosition.Lon)
+	}
+	if a.RDst != nil || a.RDir != nil {
+		t.Errorf("r_dst, r_dir = %v, %v, want removed", a.RDst, a.RDir)
+	}
+	if *lat != 51.4706 || last.Lat != 51.0001 {
+		t.Error("CoarsenAircraft() modified coordinates shared with another copy")
+	}
+
+	unchanged := models.Aircraft{Lat: models.Ptr(51.4706)}
+	newFilter(t, Config{}).CoarsenAircraft(&unchanged)
+	if *unchanged.Lat != 51.4706 {
+		t.Errorf("lat = %v without a grid, want unchanged", *unchanged.Lat)
+	}
+}
+
+func TestCoarsenEvent(t *testing.T) {
+	f := newFilter(t, Config{Grid: 1})
+
+	e := models.Event{
+		Type: "teleport",
+		Lat:  models.Ptr(51.47),
+		Lon:  models.Ptr(-0.46),
+		Details: map[string]interface{}{
+			"previous_lat": 48.85,
+			"previous_lon": 2.35,
+			"distance_nm":  190.5,
+			"note_lat":     "not a number",
+		},
+	}
+	f.CoarsenEvent(&e)
+
+	if *e.Lat != 51.5 || *e.Lon != -0.5 {
+		t.Errorf("position = %v, %v, want 51.5, -0.5", *e.Lat, *e.Lon)
+	}
+	if e.Details["previous_lat"] != 48.5 || e.Details["previous_lon"] != 2.5 {
+		t.Errorf("details position = %v, %v, want 48.5, 2.5", e.Details["previous_lat"], e.Details["previous_lon"])
+	}
+	if e.Details["distance_nm"] != 190.5 || e.Details["note_lat"] != "not a number" {
+		t.Errorf("details = %v, want other keys unchanged", e.Details)
+	}
+}
@@ -0,0 +1,204 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/metrics"
+)
+
+const (
+	// queueSize is how many polling cycles each sink may fall behind by
+	// before new ones are dropped for it
+	queueSize = 16
+	// maxAttempts is how often a batch is written before it is given up on
+	maxAttempts = 5
+)
+
+// initialBackoff doubles after every failed attempt, up to maxBackoff. They
+// are variables so tests don't have to wait them out.
+var (
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// Fanout writes every batch to several sinks. Each sink has its own queue and
+// goroutine, so a slow or failing sink only ever delays itself: its batches
+// are retried with backoff while the others carry on, and once its queue is
+// full new batches are dropped for it alone. Records are shared between the
+// sinks and must not be modified by them.
+type Fanout struct {
+	// mu guards closed against Write and Flush sending on closed queues
+	mu     sync.RWMutex
+	closed bool
+	queues []*queue
+}
+
+type queue struct {
+	name  string
+	sink  Sink
+	items chan item
+	// stop cuts retries short once the fanout is closing
+	stop chan struct{}
+	done chan struct{}
+}
+
+// item is either a batch of records or, when flushed is set, a request to
+// flush the sink once everything queued before it has been written.
+type item struct {
+	records []Record
+	flushed chan error
+}
+
+// NewFanout starts a queue for every target.
+func NewFanout(targets ...Target) *Fanout {
+	logging.DebugCall("sink.NewFanout", "sinks", len(targets))
+
+	f := &Fanout{}
+	for _, t := range targets {
+		q := &queue{
+			name:  t.Name,
+			sink:  t.Sink,
+			items: make(chan item, queueSize),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		f.queues = append(f.queues, q)
+		go q.run()
+
+		logging.Info("Output sink configured", "sink", t.Name)
+	}
+	return f
+}
+
+// Write queues the records for every sink without waiting for them to be
+// delivered. It only fails once the fanout is closed.
+func (f *Fanout) Write(ctx context.Context, records []Record) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return fmt.Errorf("sink is closed")
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	for _, q := range f.queues {
+		select {
+		case q.items <- item{records: records}:
+			metrics.ObserveSinkQueue(q.name, len(q.items))
+		default:
+			metrics.ObserveSinkDropped(q.name, len(records))
+			logging.Warn("Sink queue is full, dropping records", "sink", q.name, "records", len(records), "queue_size", queueSize)
+		}
+	}
+	return nil
+}
+
+// Flush waits for every sink to write what is queued for it, then flushes
+// it. Errors from individual sinks are joined.
+func (f *Fanout) Flush(ctx context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return fmt.Errorf("sink is closed")
+	}
+
+	results := make([]chan error, len(f.queues))
+	for i, q := range f.queues {
+		results[i] = make(chan error, 1)
+		select {
+		case q.items <- item{flushed: results[i]}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var errs []error
+	for i, q := range f.queues {
+		select {
+		case err := <-results[i]:
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush %s: %w", q.name, err))
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close gives every sink one last attempt at what is still queued for it and
+// then closes it.
+func (f *Fanout) Close() error {
+	logging.DebugCall("sink.Fanout.Close", "sinks", len(f.queues))
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for _, q := range f.queues {
+		close(q.stop)
+		close(q.items)
+	}
+	f.mu.Unlock()
+
+	var errs []error
+	for _, q := range f.queues {
+		<-q.done
+		if err := q.sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s: %w", q.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (q *queue) run() {
+	defer close(q.done)
+
+	for it := range q.items {
+		metrics.ObserveSinkQueue(q.name, len(q.items))
+		if it.flushed != nil {
+			it.flushed <- q.sink.Flush(context.Background())
+			continue
+		}
+		q.deliver(it.records)
+	}
+}
+
+// deliver writes a batch, retrying with backoff until it succeeds, runs out
+// of attempts or the fanout is closed.
+func (q *queue) deliver(records []Record) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := q.sink.Write(context.Background(), records)
+		if err == nil {
+			return
+		}
+		metrics.ObserveSinkFailure(q.name)
+
+		if attempt >= maxAttempts {
+			metrics.ObserveSinkDropped(q.name, len(records))
+			logging.Error("Giving up writing records to sink", "error", err, "sink", q.name, "records", len(records), "attempts", attempt)
+			return
+		}
+		logging.Warn("Failed to write records to sink, retrying", "error", err, "sink", q.name, "records", len(records), "attempt", attempt, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-q.stop:
+			metrics.ObserveSinkDropped(q.name, len(records))
+			logging.Error("Dropping records for sink on shutdown", "error", err, "sink", q.name, "records", len(records))
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
@@ -0,0 +1,283 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records what it is asked to do. Its writes can be made to fail or
+// to block until released.
+type fakeSink struct {
+	mu  sync.Mutex
+	ops []string
+	// attempts counts calls to Write, failed or not
+	attempts int
+	// fail is how many writes fail before they succeed; negative fails all
+	fail     int
+	flushErr error
+	closed   int
+
+	// block, when set, holds every write until it is closed
+	block chan struct{}
+	// entered receives a value as each write starts
+	entered chan struct{}
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{entered: make(chan struct{}, 100)}
+}
+
+func (f *fakeSink) Write(ctx context.Context, records []Record) error {
+	select {
+	case f.entered <- struct{}{}:
+	default:
+	}
+	if f.block != nil {
+		<-f.block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if f.fail != 0 {
+		f.fail--
+		return fmt.Errorf("write failed")
+	}
+	f.ops = append(f.ops, records[0].Line)
+	return nil
+}
+
+func (f *fakeSink) Flush(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ops = append(f.ops, "flush")
+	return f.flushErr
+}
+
+func (f *fakeSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed++
+	return nil
+}
+
+func (f *fakeSink) written() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.ops...)
+}
+
+func batch(line string) []Record {
+	return []Record{{Line: line, Kind: KindAircraft}}
+}
+
+// fastBackoff shortens the retry backoff for the test.
+func fastBackoff(t *testing.T, d time.Duration) {
+	initial, max := initialBackoff, maxBackoff
+	initialBackoff, maxBackoff = d, d
+	t.Cleanup(func() { initialBackoff, maxBackoff = initial, max })
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFailingSinkDoesNotBlockOthers(t *testing.T) {
+	stuck, healthy := newFakeSink(), newFakeSink()
+	stuck.block = make(chan struct{})
+	f := NewFanout(Target{Name: "stuck", Sink: stuck}, Target{Name: "healthy", Sink: healthy})
+
+	for _, line := range []string{"1", "2", "3"} {
+		if err := f.Write(context.Background(), batch(line)); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+	}
+	waitFor(t, "the healthy sink", func() bool { return len(healthy.written()) == 3 })
+
+	close(stuck.block)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if got := stuck.written(); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("stuck sink wrote %v once released, want every batch", got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	fastBackoff(t, time.Millisecond)
+
+	tests := map[string]struct {
+		fail     int
+		attempts int
+		written  []string
+	}{
+		"recovers":     {2, 4, []string{"1", "2", "flush"}},
+		"gives up":     {-1, 2 * maxAttempts, []string{"flush"}},
+		"first time":   {0, 2, []string{"1", "2", "flush"}},
+		"last attempt": {maxAttempts - 1, maxAttempts + 1, []string{"1", "2", "flush"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeSink()
+			s.fail = tt.fail
+			f := NewFanout(Target{Name: "fake", Sink: s})
+			defer f.Close()
+
+			f.Write(context.Background(), batch("1"))
+			f.Write(context.Background(), batch("2"))
+			// The flush is queued behind both batches, so it returns once
+			// they have been delivered or given up on
+			if err := f.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush() = %v", err)
+			}
+
+			if s.attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", s.attempts, tt.attempts)
+			}
+			if got := s.written(); !reflect.DeepEqual(got, tt.written) {
+				t.Errorf("written = %v, want %v", got, tt.written)
+			}
+		})
+	}
+}
+
+func TestFullQueueDropsForThatSinkOnly(t *testing.T) {
+	slow, fast := newFakeSink(), newFakeSink()
+	slow.block = make(chan struct{})
+	f := NewFanout(Target{Name: "slow", Sink: slow}, Target{Name: "fast", Sink: fast})
+
+	// The first batch is taken off the queue and held in Write, then the
+	// queue fills up and the last two batches are dropped for the slow sink
+	f.Write(context.Background(), batch("0"))
+	<-slow.entered
+	var want []string
+	for i := 0; i < queueSize+2; i++ {
+		line := fmt.Sprint(i + 1)
+		f.Write(context.Background(), batch(line))
+		want = append(want, line)
+		// Keep pace with the fast sink, so only the slow one falls behind
+		waitFor(t, "the fast sink", func() bool { return len(fast.written()) == i+2 })
+	}
+
+	close(slow.block)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	if got := fast.written(); !reflect.DeepEqual(got, append([]string{"0"}, want...)) {
+		t.Errorf("fast sink wrote %v, want every batch", got)
+	}
+	if got := slow.written(); !reflect.DeepEqual(got, append([]string{"0"}, want[:queueSize]...)) {
+		t.Errorf("slow sink wrote %v, want the first %d batches", got, queueSize+1)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	a, b := newFakeSink(), newFakeSink()
+	b.flushErr = errors.New("disk full")
+	f := NewFanout(Target{Name: "a", Sink: a}, Target{Name: "b", Sink: b})
+	defer f.Close()
+
+	f.Write(context.Background(), batch("1"))
+	f.Write(context.Background(), batch("2"))
+	err := f.Flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to flush b: disk full") {
+		t.Errorf("Flush() = %v, want b's error", err)
+	}
+	f.Write(context.Background(), batch("3"))
+	f.Flush(context.Background())
+
+	want := []string{"1", "2", "flush", "3", "flush"}
+	for name, s := range map[string]*fakeSink{"a": a, "b": b} {
+		if got := s.written(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ops = %v, want %v", name, got, want)
+		}
+	}
+
+	if err := f.Write(context.Background(), nil); err != nil {
+		t.Errorf("Write(nil) = %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := newFakeSink()
+	s.block = make(chan struct{})
+	f := NewFanout(Target{Name: "fake", Sink: s})
+
+	for _, line := range []string{"1", "2", "3"} {
+		f.Write(context.Background(), batch(line))
+	}
+	closed := make(chan error)
+	go func() { closed <- f.Close() }()
+
+	// Close waits for what is queued before it closes the sink
+	<-s.entered
+	close(s.block)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if got := s.written(); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("written = %v, want every queued batch", got)
+	}
+	if s.closed != 1 {
+		t.Errorf("sink closed %d times, want 1", s.closed)
+	}
+
+	if err := f.Write(context.Background(), batch("4")); err == nil {
+		t.Error("Write() after Close() succeeded")
+	}
+	if err := f.Flush(context.Background()); err == nil {
+		t.Error("Flush() after Close() succeeded")
+	}
+	if err := f.Close(); err != nil || s.closed != 1 {
+		t.Errorf("second Close() = %v, sink closed %d times", err, s.closed)
+	}
+}
+
+// Close drops a failing sink's batch rather than waiting out its backoff.
+func TestCloseCutsRetriesShort(t *testing.T) {
+	fastBackoff(t, time.Hour)
+
+	s := newFakeSink()
+	s.fail = -1
+	f := NewFanout(Target{Name: "fake", Sink: s})
+	f.Write(context.Background(), batch("1"))
+	<-s.entered
+
+	done := make(chan error)
+	go func() { done <- f.Close() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() waited for the retry backoff")
+	}
+	if s.closed != 1 {
+		t.Errorf("sink closed %d times, want 1", s.closed)
+	}
+}
+
+func TestNew(t *testing.T) {
+	only := newFakeSink()
+	if _, ok := New(Target{Name: "only", Sink: only}).(*Fanout); ok {
+		t.Error("New() fanned out a single target")
+	}
+	f := New(Target{Name: "a", Sink: newFakeSink()}, Target{Name: "b", Sink: newFakeSink()})
+	if _, ok := f.(*Fanout); !ok {
+		t.Errorf("New() = %T for two targets, want a fanout", f)
+	}
+	f.Close()
+}
@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Kind is what a record describes.
+type Kind string
+
+const (
+	KindAircraft Kind = "aircraft"
+	KindEvent    Kind = "event"
+	KindStats    Kind = "stats"
+	// KindSnapshot is a receiver's whole aircraft document, decoded but not
+	// validated. Only sinks that archive snapshots receive it.
+	KindSnapshot Kind = "snapshot"
+)
+
+// Record is one output of a polling cycle. Line and Labels are what Loki
+// stores; the structured value behind the line is kept alongside, so sinks
+// that need more than a JSON string don't have to parse it back. Exactly one
+// of Aircraft, Event, Stats and Snapshot is set, matching Kind.
+type Record struct {
+	Timestamp time.Time
+	Labels    map[string]string
+	Line      string
+	Kind      Kind
+	// Receiver is the name of the receiver the record came from, if any
+	Receiver string
+
+	Aircraft *models.Aircraft
+	Event    *models.Event
+	Stats    *models.StatsPeriod
+	Snapshot *models.Dump1090fa
+}
+
+// Sink is a destination for records. Write is called once per polling cycle
+// with every record of the cycle and may buffer them; Flush delivers anything
+// buffered, and Close flushes and releases the sink.
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// Target is a sink with the name it is logged and measured under.
+type Target struct {
+	Name string
+	Sink Sink
+	// Snapshots is set for sinks that take snapshot records; the others
+	// never see them
+	Snapshots bool
+}
+
+// New returns the sink to write to: a single target is used directly, so its
+// errors reach the polling loop as before, while several are fanned out.
+func New(targets ...Target) Sink {
+	for i, t := range targets {
+		if !t.Snapshots {
+			targets[i].Sink = withoutSnapshots{t.Sink}
+		}
+	}
+	if len(targets) == 1 {
+		return targets[0].Sink
+	}
+	return NewFanout(targets...)
+}
+
+// withoutSnapshots drops snapshot records before they reach a sink.
+type withoutSnapshots struct {
+	Sink
+}
+
+func (s withoutSnapshots) Write(ctx context.Context, records []Record) error {
+	kept := records
+	for i, r := range records {
+		if r.Kind != KindSnapshot {
+			continue
+		}
+		// Records are shared between sinks, so filter into a copy
+		kept = make([]Record, 0, len(records))
+		kept = append(kept, records[:i]...)
+		for _, r := range records[i+1:] {
+			if r.Kind != KindSnapshot {
+				kept = append(kept, r)
+			}
+		}
+		break
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return s.Sink.Write(ctx, kept)
+}
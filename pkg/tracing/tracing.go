@@ -8,43 +8,34 @@ import (
 	"strings"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+// OTLP signals, as used in the names of their environment variables and
+// endpoint paths.
+const (
+	SignalTraces  = "traces"
+	SignalLogs    = "logs"
+	SignalMetrics = "metrics"
+)
+
 func InitTracing() (func(), error) {
 	// Check if tracing is enabled
-	if enabled := getEnv("OTEL_TRACING_ENABLED", "false"); !isTrue(enabled) {
+	if enabled := getEnv("OTEL_TRACING_ENABLED", "false"); !IsTrue(enabled) {
 		log.Println("OpenTelemetry tracing is disabled")
 		return func() {}, nil
 	}
 
-	// Get OTLP endpoint from environment variables
-	endpoint := getOTLPEndpoint()
-
-	// Check if connection should be insecure
-	insecure := isTrue(getEnv("OTEL_EXPORTER_OTLP_TRACES_INSECURE", "true"))
-
-	// Parse headers if provided
-	headers := parseHeaders(getEnv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", ""))
-
-	// Create OTLP exporter options
-	opts := []otlptracehttp.Option{
-		otlptracehttp.WithEndpoint(endpoint),
-	}
-
-	if insecure {
-		opts = append(opts, otlptracehttp.WithInsecure())
-	}
-
-	if len(headers) > 0 {
-		opts = append(opts, otlptracehttp.WithHeaders(headers))
-	}
-
 	// Create OTLP exporter
+	opts := ExporterOptions(SignalTraces,
+		otlptracehttp.WithEndpoint, otlptracehttp.WithURLPath, otlptracehttp.WithInsecure, otlptracehttp.WithHeaders)
 	exporter, err := otlptracehttp.New(context.Background(), opts...)
 	if err != nil {
 		log.Printf("Failed to create OTLP exporter, using noop: %v", err)
@@ -52,25 +43,7 @@ func InitTracing() (func(), error) {
 		return func() {}, nil
 	}
 
-	// Create resource with Go-specific attributes
-	res, err := resource.New(context.Background(),
-		resource.WithAttributes(
-			// Service identification
-			semconv.ServiceName("adsb2loki"),
-			semconv.ServiceVersion("1.0.0"),
-
-			// Process and runtime information
-			semconv.ProcessRuntimeName("go"),
-			semconv.ProcessRuntimeVersion(runtime.Version()),
-			semconv.ProcessRuntimeDescription("Go runtime"),
-			semconv.ProcessPID(os.Getpid()),
-
-			// Telemetry SDK information
-			semconv.TelemetrySDKName("opentelemetry"),
-			semconv.TelemetrySDKLanguageGo,
-			semconv.TelemetrySDKVersion("1.31.0"),
-		),
-	)
+	res, err := Resource()
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +67,70 @@ func InitTracing() (func(), error) {
 	}, nil
 }
 
+// InitMetrics exports the application's OpenTelemetry metrics over OTLP,
+// using the same endpoint configuration as traces with METRICS as the signal,
+// and the traces settings themselves when neither is set. The export
+// interval is read by the SDK from OTEL_METRIC_EXPORT_INTERVAL.
+func InitMetrics() (func(), error) {
+	if enabled := getEnv("OTEL_METRICS_ENABLED", "false"); !IsTrue(enabled) {
+		log.Println("OpenTelemetry metrics are disabled")
+		return func() {}, nil
+	}
+
+	opts := ExporterOptions(SignalMetrics,
+		otlpmetrichttp.WithEndpoint, otlpmetrichttp.WithURLPath, otlpmetrichttp.WithInsecure, otlpmetrichttp.WithHeaders)
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		log.Printf("Failed to create OTLP metric exporter, using noop: %v", err)
+		return func() {}, nil
+	}
+
+	res, err := Resource()
+	if err != nil {
+		return nil, err
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	// Instruments created from the global provider before this point are
+	// delegated to the new one
+	otel.SetMeterProvider(mp)
+
+	log.Println("OpenTelemetry metrics initialized successfully")
+
+	return func() {
+		if err := mp.Shutdown(context.Background()); err != nil {
+			log.Printf("Error shutting down meter provider: %v", err)
+		}
+	}, nil
+}
+
+// Resource describes this service, with any extra attributes, to OTLP
+// backends.
+func Resource(attrs ...attribute.KeyValue) (*resource.Resource, error) {
+	attrs = append([]attribute.KeyValue{
+		// Service identification
+		semconv.ServiceName("adsb2loki"),
+		semconv.ServiceVersion("1.0.0"),
+
+		// Process and runtime information
+		semconv.ProcessRuntimeName("go"),
+		semconv.ProcessRuntimeVersion(runtime.Version()),
+		semconv.ProcessRuntimeDescription("Go runtime"),
+		semconv.ProcessPID(os.Getpid()),
+
+		// Telemetry SDK information
+		semconv.TelemetrySDKName("opentelemetry"),
+		semconv.TelemetrySDKLanguageGo,
+		semconv.TelemetrySDKVersion("1.31.0"),
+	}, attrs...)
+
+	return resource.New(context.Background(), resource.WithAttributes(attrs...))
+}
+
 // getEnv returns the value of an environment variable or a default value if not set
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -102,21 +139,41 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// isTrue checks if a string represents a true value
-func isTrue(s string) bool {
+// IsTrue checks if a setting represents a true value: true, 1, yes or on,
+// in any case
+func IsTrue(s string) bool {
 	s = strings.ToLower(strings.TrimSpace(s))
 	return s == "true" || s == "1" || s == "yes" || s == "on"
 }
 
-// getOTLPEndpoint determines the OTLP endpoint from environment variables
-func getOTLPEndpoint() string {
-	// Check for traces-specific endpoint first
-	if endpoint := getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""); endpoint != "" {
-		return cleanEndpoint(endpoint)
+// ExporterOptions builds the options of an OTLP HTTP exporter for a signal
+// from the constructors of its package, such as otlptracehttp.WithEndpoint.
+// The path is always set: the exporters read the same variables themselves
+// and would otherwise post to "/" when a signal-specific endpoint without a
+// path is set.
+func ExporterOptions[O interface{}](signal string,
+	withEndpoint func(string) O,
+	withURLPath func(string) O,
+	withInsecure func() O,
+	withHeaders func(map[string]string) O,
+) []O {
+	opts := []O{
+		withEndpoint(Endpoint(signal)),
+		withURLPath("/v1/" + signal),
 	}
+	if Insecure(signal) {
+		opts = append(opts, withInsecure())
+	}
+	if headers := Headers(signal); len(headers) > 0 {
+		opts = append(opts, withHeaders(headers))
+	}
+	return opts
+}
 
-	// Fall back to general OTLP endpoint
-	if endpoint := getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""); endpoint != "" {
+// Endpoint determines the OTLP endpoint for a signal from environment
+// variables
+func Endpoint(signal string) string {
+	if endpoint := setting(signal, "ENDPOINT"); endpoint != "" {
 		return cleanEndpoint(endpoint)
 	}
 
@@ -124,20 +181,55 @@ func getOTLPEndpoint() string {
 	return "localhost:4318"
 }
 
+// Headers returns the headers to send with a signal
+func Headers(signal string) map[string]string {
+	return parseHeaders(setting(signal, "HEADERS"))
+}
+
+// Insecure reports whether a signal is exported over plain HTTP. It defaults
+// to true, for a collector on the same host.
+func Insecure(signal string) bool {
+	if insecure := setting(signal, "INSECURE"); insecure != "" {
+		return IsTrue(insecure)
+	}
+	return true
+}
+
+// setting reads an exporter setting for a signal from the signal-specific
+// variable, such as OTEL_EXPORTER_OTLP_LOGS_ENDPOINT, then the general one,
+// then the traces one, so that a deployment configured for traces alone
+// exports every signal to the same place.
+func setting(signal, name string) string {
+	if value := getEnv(signalEnv(signal, name), ""); value != "" {
+		return value
+	}
+	if value := getEnv("OTEL_EXPORTER_OTLP_"+name, ""); value != "" {
+		return value
+	}
+	return getEnv(signalEnv(SignalTraces, name), "")
+}
+
+// signalEnv returns the name of a signal-specific variable, such as
+// OTEL_EXPORTER_OTLP_LOGS_ENDPOINT
+func signalEnv(signal, name string) string {
+	return "OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_" + name
+}
+
 // cleanEndpoint removes protocol and path from endpoint URL
 func cleanEndpoint(endpoint string) string {
 	// Remove http:// or https:// prefix if present
 	endpoint = strings.TrimPrefix(endpoint, "http://")
 	endpoint = strings.TrimPrefix(endpoint, "https://")
-	
-	// Remove /v1/traces suffix if present since WithEndpoint handles the path separately
-	if strings.HasSuffix(endpoint, "/v1/traces") {
-		endpoint = strings.TrimSuffix(endpoint, "/v1/traces")
-	}
-	
+
 	// Remove any trailing slashes
 	endpoint = strings.TrimSuffix(endpoint, "/")
-	
+
+	// Remove the signal path suffix if present since the path is set
+	// separately; it may be another signal's when falling back to traces
+	for _, signal := range []string{SignalTraces, SignalLogs, SignalMetrics} {
+		endpoint = strings.TrimSuffix(endpoint, "/v1/"+signal)
+	}
+
 	return endpoint
 }
 
@@ -0,0 +1,200 @@
+package validation
+
+import (
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// Reason describes why an aircraft failed validation.
+type Reason string
+
+const (
+	ReasonInvalidHex   Reason = "invalid_hex"
+	ReasonLatitude     Reason = "latitude_out_of_range"
+	ReasonLongitude    Reason = "longitude_out_of_range"
+	ReasonAltitude     Reason = "altitude_out_of_range"
+	ReasonSpeed        Reason = "speed_out_of_range"
+	ReasonVerticalRate Reason = "vertical_rate_out_of_range"
+	ReasonNotANumber   Reason = "not_a_number"
+	ReasonDuplicate    Reason = "duplicate_hex"
+)
+
+// Physical limits beyond which a decoded value can't be real. They are
+// deliberately generous: high-altitude balloons fly above 100,000 ft and
+// military jets exceed 1,500 kt.
+const (
+	minAltitude     = -2000
+	maxAltitude     = 130000
+	maxSpeed        = 2500
+	maxVerticalRate = 60000
+)
+
+// Snapshot sanitizes every aircraft of a single receiver snapshot in place
+// and returns the reasons each one is invalid, indexed like aircraft. A nil
+// entry means the aircraft is valid. When an address appears more than once
+// the most recently seen valid report is kept and the others are duplicates.
+func Snapshot(aircraft []models.Aircraft) [][]Reason {
+	logging.DebugCall("validation.Snapshot", "aircraft_count", len(aircraft))
+
+	reasons := make([][]Reason, len(aircraft))
+	for i := range aircraft {
+		Sanitize(&aircraft[i])
+		reasons[i] = Check(aircraft[i])
+	}
+
+	kept := make(map[string]int, len(aircraft))
+	for i := range aircraft {
+		hex := aircraft[i].Hex
+		j, seen := kept[hex]
+		if !seen {
+			kept[hex] = i
+			continue
+		}
+
+		if preferred(aircraft[i], reasons[i], aircraft[j], reasons[j]) {
+			reasons[j] = append(reasons[j], ReasonDuplicate)
+			kept[hex] = i
+		} else {
+			reasons[i] = append(reasons[i], ReasonDuplicate)
+		}
+	}
+
+	return reasons
+}
+
+// preferred reports whether candidate should be kept over existing when both
+// share an address.
+func preferred(candidate models.Aircraft, candidateReasons []Reason, existing models.Aircraft, existingReasons []Reason) bool {
+	if (len(candidateReasons) == 0) != (len(existingReasons) == 0) {
+		return len(candidateReasons) == 0
+	}
+	return candidate.Seen < existing.Seen
+}
+
+// Sanitize normalizes formatting differences between sources: addresses are
+// lowercased and callsigns and squawks lose their space padding.
+func Sanitize(a *models.Aircraft) {
+	a.Hex = strings.ToLower(strings.TrimSpace(a.Hex))
+	a.Flight = strings.TrimSpace(a.Flight)
+	a.Squawk = strings.TrimSpace(a.Squawk)
+}
+
+// Check returns every reason the aircraft is invalid, or nil.
+func Check(a models.Aircraft) []Reason {
+	var reasons []Reason
+
+	if !validHex(a.Hex) {
+		reasons = append(reasons, ReasonInvalidHex)
+	}
+
+	if hasNaN(a) {
+		// Range checks are meaningless on NaN or Inf
+		return append(reasons, ReasonNotANumber)
+	}
+
+	if a.Lat != nil && (*a.Lat < -90 || *a.Lat > 90) {
+		reasons = append(reasons, ReasonLatitude)
+	}
+	if a.Lon != nil && (*a.Lon < -180 || *a.Lon > 180) {
+		reasons = append(reasons, ReasonLongitude)
+	}
+	if outOfRange(a.AltBaro, minAltitude, maxAltitude) || outOfRange(a.AltGeom, minAltitude, maxAltitude) {
+		reasons = append(reasons, ReasonAltitude)
+	}
+	if a.Gs != nil && (*a.Gs < 0 || *a.Gs > maxSpeed) {
+		reasons = append(reasons, ReasonSpeed)
+	}
+	if outOfRange(a.Ias, 0, maxSpeed) || outOfRange(a.Tas, 0, maxSpeed) {
+		reasons = append(reasons, ReasonSpeed)
+	}
+	if outOfRange(a.BaroRate, -maxVerticalRate, maxVerticalRate) || outOfRange(a.GeomRate, -maxVerticalRate, maxVerticalRate) {
+		reasons = append(reasons, ReasonVerticalRate)
+	}
+
+	return reasons
+}
+
+// validHex accepts a 24-bit address as six hex digits, optionally prefixed
+// with "~" for non-ICAO (TIS-B, ADS-R, UAT) addresses.
+func validHex(hex string) bool {
+	hex = strings.TrimPrefix(hex, "~")
+	if len(hex) != 6 {
+		return false
+	}
+	for _, c := range hex {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func outOfRange(v *int, min, max int) bool {
+	return v != nil && (*v < min || *v > max)
+}
+
+var floatPtrType = reflect.TypeOf((*float64)(nil))
+
+// hasNaN reports whether any float field is NaN or infinite, including those
+// of nested structs such as lastPosition. JSON can't carry either, but the
+// protobuf and mapped sources can.
+func hasNaN(a models.Aircraft) bool {
+	return nonFinite(reflect.ValueOf(&a).Elem(), false)
+}
+
+// ClearNaN removes NaN and infinite values from the aircraft, so that it can
+// still be encoded as JSON once it has been flagged as not_a_number. Optional
+// fields are unset and the others zeroed. Nested structs are copied before
+// they are changed, as other copies of the aircraft may share them.
+func ClearNaN(a *models.Aircraft) {
+	nonFinite(reflect.ValueOf(a).Elem(), true)
+}
+
+// nonFinite reports whether a struct holds a NaN or infinite float, clearing
+// them as it goes when clear is set.
+func nonFinite(v reflect.Value, clear bool) bool {
+	found := false
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch {
+		case f.Kind() == reflect.Float64:
+			if notFinite(f.Float()) {
+				found = true
+				if clear {
+					f.SetFloat(0)
+				}
+			}
+		case f.Type() == floatPtrType && !f.IsNil():
+			if notFinite(f.Elem().Float()) {
+				found = true
+				if clear {
+					f.Set(reflect.Zero(f.Type()))
+				}
+			}
+		case f.Kind() == reflect.Pointer && !f.IsNil() && f.Elem().Kind() == reflect.Struct:
+			nested := reflect.New(f.Elem().Type())
+			nested.Elem().Set(f.Elem())
+			if nonFinite(nested.Elem(), clear) {
+				found = true
+				if clear {
+					f.Set(nested)
+				}
+			}
+		}
+		if found && !clear {
+			return true
+		}
+	}
+	return found
+}
+
+func notFinite(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
@@ -0,0 +1,158 @@
+package validation
+
+import (
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+func valid() models.Aircraft {
+	return models.Aircraft{
+		Hex:      "4ca1fa",
+		Lat:      models.Ptr(51.47),
+		Lon:      models.Ptr(-0.46),
+		AltBaro:  models.Ptr(35000),
+		AltGeom:  models.Ptr(35500),
+		Gs:       models.Ptr(450.5),
+		Ias:      models.Ptr(280),
+		Tas:      models.Ptr(470),
+		BaroRate: models.Ptr(-1500),
+		GeomRate: models.Ptr(-1400),
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		change func(a *models.Aircraft)
+		want   []Reason
+	}{
+		"valid":                   {func(a *models.Aircraft) {}, nil},
+		"no position or altitude": {func(a *models.Aircraft) { *a = models.Aircraft{Hex: "4ca1fa"} }, nil},
+		"non-ICAO address":        {func(a *models.Aircraft) { a.Hex = "~4ca1fa" }, nil},
+		"short address":           {func(a *models.Aircraft) { a.Hex = "4ca1f" }, []Reason{ReasonInvalidHex}},
+		"long address":            {func(a *models.Aircraft) { a.Hex = "4ca1fa0" }, []Reason{ReasonInvalidHex}},
+		"non-hex address":         {func(a *models.Aircraft) { a.Hex = "4ca1fg" }, []Reason{ReasonInvalidHex}},
+		"non-hex non-ICAO":        {func(a *models.Aircraft) { a.Hex = "~4ca1fg" }, []Reason{ReasonInvalidHex}},
+		"tilde alone":             {func(a *models.Aircraft) { a.Hex = "~" }, []Reason{ReasonInvalidHex}},
+		"unsanitized address":     {func(a *models.Aircraft) { a.Hex = "4CA1FA" }, []Reason{ReasonInvalidHex}},
+		"latitude above 90":       {func(a *models.Aircraft) { a.Lat = models.Ptr(90.1) }, []Reason{ReasonLatitude}},
+		"latitude below -90":      {func(a *models.Aircraft) { a.Lat = models.Ptr(-90.1) }, []Reason{ReasonLatitude}},
+		"latitude at the pole":    {func(a *models.Aircraft) { a.Lat = models.Ptr(90.0) }, nil},
+		"longitude above 180":     {func(a *models.Aircraft) { a.Lon = models.Ptr(180.1) }, []Reason{ReasonLongitude}},
+		"longitude below -180":    {func(a *models.Aircraft) { a.Lon = models.Ptr(-180.1) }, []Reason{ReasonLongitude}},
+		"baro altitude too high":  {func(a *models.Aircraft) { a.AltBaro = models.Ptr(130001) }, []Reason{ReasonAltitude}},
+		"geom altitude too low":   {func(a *models.Aircraft) { a.AltGeom = models.Ptr(-2001) }, []Reason{ReasonAltitude}},
+		"balloon altitude":        {func(a *models.Aircraft) { a.AltBaro = models.Ptr(120000) }, nil},
+		"negative ground speed":   {func(a *models.Aircraft) { a.Gs = models.Ptr(-1.0) }, []Reason{ReasonSpeed}},
+		"ground speed too high":   {func(a *models.Aircraft) { a.Gs = models.Ptr(2500.1) }, []Reason{ReasonSpeed}},
+		"airspeed too high":       {func(a *models.Aircraft) { a.Tas = models.Ptr(2501) }, []Reason{ReasonSpeed}},
+		"climb too fast":          {func(a *models.Aircraft) { a.BaroRate = models.Ptr(60001) }, []Reason{ReasonVerticalRate}},
+		"descent too fast":        {func(a *models.Aircraft) { a.GeomRate = models.Ptr(-60001) }, []Reason{ReasonVerticalRate}},
+		"several reasons": {
+			func(a *models.Aircraft) { a.Hex = "zzzzzz"; a.Lat = models.Ptr(91.0); a.Gs = models.Ptr(3000.0) },
+			[]Reason{ReasonInvalidHex, ReasonLatitude, ReasonSpeed},
+		},
+		"not a number": {func(a *models.Aircraft) { a.Gs = models.Ptr(math.NaN()) }, []Reason{ReasonNotANumber}},
+		"infinity skips range checks": {
+			func(a *models.Aircraft) { a.Lat = models.Ptr(math.Inf(1)); a.AltBaro = models.Ptr(200000) },
+			[]Reason{ReasonNotANumber},
+		},
+		"NaN in lastPosition": {
+			func(a *models.Aircraft) { a.LastPosition = &models.LastPosition{Lat: math.NaN()} },
+			[]Reason{ReasonNotANumber},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := valid()
+			tt.change(&a)
+			if got := Check(a); !slices.Equal(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	a := models.Aircraft{Hex: " ~4CA1FA ", Flight: "RYR1AB  ", Squawk: " 7700"}
+	Sanitize(&a)
+	if a.Hex != "~4ca1fa" || a.Flight != "RYR1AB" || a.Squawk != "7700" {
+		t.Errorf("Sanitize() = %q, %q, %q", a.Hex, a.Flight, a.Squawk)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	aircraft := []models.Aircraft{
+		{Hex: "4CA1FA", Seen: 1},
+		{Hex: "4ca1fa", Seen: 0.5},
+		{Hex: "400a0b", Seen: 0.2, Lat: models.Ptr(95.0)},
+		{Hex: "400a0b", Seen: 3},
+		{Hex: "3c6444", Seen: 2},
+	}
+	want := [][]Reason{
+		{ReasonDuplicate},
+		nil,
+		{ReasonLatitude, ReasonDuplicate},
+		nil,
+		nil,
+	}
+
+	got := Snapshot(aircraft)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v, want %v", got, want)
+	}
+	if aircraft[0].Hex != "4ca1fa" {
+		t.Errorf("hex = %q, want it sanitized", aircraft[0].Hex)
+	}
+}
+
+// TestClearNaN sets every float pointer of the model to NaN in turn, so a
+// field added later is covered without changing the test.
+func TestClearNaN(t *testing.T) {
+	typ := reflect.TypeOf(models.Aircraft{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Type != floatPtrType {
+			continue
+		}
+		t.Run(field.Name, func(t *testing.T) {
+			a := valid()
+			reflect.ValueOf(&a).Elem().Field(i).Set(reflect.ValueOf(models.Ptr(math.NaN())))
+			if !hasNaN(a) {
+				t.Fatal("hasNaN() = false")
+			}
+
+			ClearNaN(&a)
+			if !reflect.ValueOf(a).Field(i).IsNil() {
+				t.Errorf("%s = %v, want unset", field.Name, *reflect.ValueOf(a).Field(i).Interface().(*float64))
+			}
+			if hasNaN(a) {
+				t.Error("hasNaN() = true after ClearNaN()")
+			}
+			if a.Lat == nil && field.Name != "Lat" {
+				t.Error("ClearNaN() unset a finite field")
+			}
+		})
+	}
+
+	t.Run("nested and plain fields", func(t *testing.T) {
+		last := &models.LastPosition{Lat: math.Inf(-1), Lon: 1.5}
+		a := valid()
+		a.Seen = math.NaN()
+		a.LastPosition = last
+		ClearNaN(&a)
+
+		if a.Seen != 0 {
+			t.Errorf("seen = %v, want 0", a.Seen)
+		}
+		if a.LastPosition.Lat != 0 || a.LastPosition.Lon != 1.5 {
+			t.Errorf("lastPosition = %+v, want lat zeroed and lon kept", *a.LastPosition)
+		}
+		if !math.IsInf(last.Lat, -1) {
+			t.Error("ClearNaN() modified a lastPosition shared with another copy")
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package watchlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/burnettdev/adsb2loki/pkg/logging"
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+// EventSpotted is the event type emitted when a watched aircraft appears.
+const EventSpotted = "spotted"
+
+// Tags given to aircraft flagged in the aircraft database, when no watchlist
+// entry matches first.
+const (
+	TagMilitary    = "military"
+	TagInteresting = "interesting"
+)
+
+// sessionGap is how long, in seconds, a watched aircraft must be out of
+// range before it is spotted again.
+const sessionGap = 1800.0
+
+// Entry matches aircraft by any combination of address, registration, type
+// and callsign; all the criteria set must match. Registration, type and
+// callsign accept shell-style patterns such as "UKP*".
+type Entry struct {
+	Tag          string `json:"tag"`
+	Hex          string `json:"hex,omitempty"`
+	Registration string `json:"registration,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Callsign     string `json:"callsign,omitempty"`
+}
+
+// Watchlist tags aircraft from a list of entries and the dbFlags military
+// and interesting bits, and tracks which tagged aircraft have already been
+// spotted. It is not safe for concurrent use.
+type Watchlist struct {
+	entries []Entry
+	// lastSeen is when each spotted aircraft was last matched
+	lastSeen map[string]float64
+}
+
+// New returns a watchlist of the given entries.
+func New(entries []Entry) (*Watchlist, error) {
+	logging.DebugCall("watchlist.New", "entries", len(entries))
+
+	for i, e := range entries {
+		if e.Tag == "" {
+			return nil, fmt.Errorf("entry %d has no tag", i)
+		}
+		if e.Hex == "" && e.Registration == "" && e.Type == "" && e.Callsign == "" {
+			return nil, fmt.Errorf("entry %q matches nothing", e.Tag)
+		}
+		for _, pattern := range []string{e.Registration, e.Type, e.Callsign} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("entry %q: invalid pattern %q: %w", e.Tag, pattern, err)
+			}
+		}
+
+		entries[i].Hex = strings.ToLower(e.Hex)
+		entries[i].Registration = strings.ToUpper(e.Registration)
+		entries[i].Type = strings.ToUpper(e.Type)
+		entries[i].Callsign = strings.ToUpper(e.Callsign)
+	}
+
+	return &Watchlist{
+		entries:  entries,
+		lastSeen: make(map[string]float64),
+	}, nil
+}
+
+// Load reads a JSON array of entries from a file.
+func Load(filePath string) (*Watchlist, error) {
+	logging.DebugCall("watchlist.Load", "path", filePath)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watchlist: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse watchlist: %w", err)
+	}
+
+	w, err := New(entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watchlist %s: %w", filePath, err)
+	}
+
+	logging.Debug("Watchlist loaded", "path", filePath, "entries", len(entries))
+	return w, nil
+}
+
+// Match returns the tags of every entry the aircraft matches, followed by
+// the tags for its dbFlags.
+func (w *Watchlist) Match(a models.Aircraft) []string {
+	var tags []string
+	for _, e := range w.entries {
+		if e.matches(a) {
+			tags = append(tags, e.Tag)
+		}
+	}
+	if a.IsMilitary() {
+		tags = append(tags, TagMilitary)
+	}
+	if a.IsInteresting() {
+		tags = append(tags, TagInteresting)
+	}
+	return tags
+}
+
+// Spot returns a spotted event the first time a tagged aircraft is seen, and
+// again only once it has been out of range for a while.
+func (w *Watchlist) Spot(a models.Aircraft, tags []string, now float64) (models.Event, bool) {
+	last, seen := w.lastSeen[a.Hex]
+	w.lastSeen[a.Hex] = now
+	if seen && now-last <= sessionGap {
+		return models.Event{}, false
+	}
+
+	event := models.NewEvent(EventSpotted, now, a)
+	event.Details = map[string]interface{}{
+		"tags":         tags,
+		"registration": a.R,
+		"aircraft":     a.T,
+		"description":  a.Desc,
+		"db_flags":     a.DbFlagNames(),
+	}
+
+	logging.Info("Watched aircraft spotted", "hex", a.Hex, "flight", a.Flight, "registration", a.R, "tags", tags)
+	return event, true
+}
+
+// Expire forgets spotted aircraft that have been gone for longer than a
+// session.
+func (w *Watchlist) Expire(now float64) {
+	for hex, last := range w.lastSeen {
+		if now-last > sessionGap {
+			delete(w.lastSeen, hex)
+		}
+	}
+}
+
+func (e Entry) matches(a models.Aircraft) bool {
+	if e.Hex != "" && e.Hex != a.Hex {
+		return false
+	}
+	return match(e.Registration, a.R) && match(e.Type, a.T) && match(e.Callsign, a.Flight)
+}
+
+func match(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, strings.ToUpper(strings.TrimSpace(value)))
+	return ok
+}
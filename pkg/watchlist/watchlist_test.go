@@ -0,0 +1,147 @@
+package watchlist
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/burnettdev/adsb2loki/pkg/models"
+)
+
+func newWatchlist(t *testing.T, entries ...Entry) *Watchlist {
+	t.Helper()
+
+	w, err := New(entries)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	return w
+}
+
+func TestMatch(t *testing.T) {
+	w := newWatchlist(t,
+		Entry{Tag: "police", Callsign: "ukp*"},
+		Entry{Tag: "airforceone", Hex: "ADFDF8"},
+		Entry{Tag: "g-reg", Registration: "G-????"},
+		Entry{Tag: "a380", Type: "A38[0-9]"},
+		Entry{Tag: "ba-a380", Type: "A388", Callsign: "BAW*"},
+	)
+
+	tests := map[string]struct {
+		aircraft models.Aircraft
+		want     []string
+	}{
+		"callsign pattern, padded":  {models.Aircraft{Hex: "43c6f1", Flight: "UKP14   "}, []string{"police"}},
+		"callsign in lower case":    {models.Aircraft{Hex: "43c6f1", Flight: "ukp14"}, []string{"police"}},
+		"address in any case":       {models.Aircraft{Hex: "adfdf8"}, []string{"airforceone"}},
+		"registration wildcard":     {models.Aircraft{Hex: "406a3b", R: "G-EUPT"}, []string{"g-reg"}},
+		"registration too long":     {models.Aircraft{Hex: "406a3b", R: "G-EUPTX"}, nil},
+		"type character class":      {models.Aircraft{Hex: "400a0b", T: "A388"}, []string{"a380"}},
+		"all criteria must match":   {models.Aircraft{Hex: "400a0b", T: "A388", Flight: "BAW12"}, []string{"a380", "ba-a380"}},
+		"one criterion fails":       {models.Aircraft{Hex: "896180", T: "A388", Flight: "UAE1"}, []string{"a380"}},
+		"nothing matches":           {models.Aircraft{Hex: "4ca1fa", Flight: "RYR1AB", T: "B738"}, nil},
+		"military flag":             {models.Aircraft{Hex: "43c001", DbFlags: models.DbFlagMilitary}, []string{TagMilitary}},
+		"interesting flag":          {models.Aircraft{Hex: "43c001", DbFlags: models.DbFlagInteresting}, []string{TagInteresting}},
+		"entries before flags":      {models.Aircraft{Hex: "43c6f1", Flight: "UKP14", DbFlags: models.DbFlagMilitary | models.DbFlagInteresting}, []string{"police", TagMilitary, TagInteresting}},
+		"privacy flags aren't tags": {models.Aircraft{Hex: "a1b2c3", DbFlags: models.DbFlagPIA | models.DbFlagLADD}, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := w.Match(tt.aircraft); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := map[string]Entry{
+		"no tag":          {Hex: "adfdf8"},
+		"no criteria":     {Tag: "empty"},
+		"invalid pattern": {Tag: "bad", Callsign: "UKP["},
+	}
+	for name, e := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New([]Entry{e}); err == nil {
+				t.Errorf("New() accepted %+v", e)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watchlist.json")
+	data := `[{"tag": "police", "callsign": "UKP*"}, {"tag": "airforceone", "hex": "adfdf8"}]`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got := w.Match(models.Aircraft{Hex: "adfdf8"}); !reflect.DeepEqual(got, []string{"airforceone"}) {
+		t.Errorf("Match() = %v", got)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`[{"tag": "police"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{invalid, filepath.Join(dir, "missing.json")} {
+		if _, err := Load(p); err == nil {
+			t.Errorf("Load(%s) succeeded", filepath.Base(p))
+		}
+	}
+}
+
+func TestSpot(t *testing.T) {
+	w := newWatchlist(t, Entry{Tag: "police", Callsign: "UKP*"})
+	a := models.Aircraft{Hex: "43c6f1", Flight: "UKP14", R: "G-POLA", T: "EC35", DbFlags: models.DbFlagInteresting}
+	tags := w.Match(a)
+
+	// Reports every few minutes keep one session going; only a gap longer
+	// than the session gap starts another
+	sightings := []struct {
+		now  float64
+		want bool
+	}{
+		{1000, true},
+		{1010, false},
+		{2800, false},
+		{4600, false},
+		{6401, true},
+	}
+	for _, s := range sightings {
+		e, ok := w.Spot(a, tags, s.now)
+		if ok != s.want {
+			t.Errorf("at %v: Spot() = %v, want %v", s.now, ok, s.want)
+		}
+		if !ok {
+			continue
+		}
+		if e.Type != EventSpotted || e.Time != s.now || e.Hex != "43c6f1" {
+			t.Errorf("event = %+v", e)
+		}
+		if !reflect.DeepEqual(e.Details["tags"], []string{"police", TagInteresting}) || e.Details["registration"] != "G-POLA" || e.Details["aircraft"] != "EC35" {
+			t.Errorf("details = %v", e.Details)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	w := newWatchlist(t, Entry{Tag: "police", Callsign: "UKP*"})
+	a := models.Aircraft{Hex: "43c6f1", Flight: "UKP14"}
+
+	w.Spot(a, w.Match(a), 1000)
+	w.Expire(1000 + sessionGap)
+	if len(w.lastSeen) != 1 {
+		t.Error("Expire() forgot an aircraft within the session gap")
+	}
+	w.Expire(1001 + sessionGap)
+	if len(w.lastSeen) != 0 {
+		t.Error("Expire() kept an aircraft gone for longer than the session gap")
+	}
+}